@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type AuthScheme string
+
+const (
+	AuthStatic AuthScheme = "static"
+	AuthHMAC   AuthScheme = "hmac"
+	AuthBearer AuthScheme = "bearer"
+)
+
+var (
+	authScheme AuthScheme
+	hmacKeyId  string
+	hmacSecret string
+)
+
+// Adds credentials to the requests to the NumerX service.
+// Called right before each attempt is sent, so that time-based signatures are fresh
+type Authenticator interface {
+	Authenticate(request *http.Request) error
+}
+
+var authenticator Authenticator
+
+// The static "EAP apikey:..." Authorization header
+type StaticKeyAuth struct {
+	Key string
+}
+
+func (auth StaticKeyAuth) Authenticate(request *http.Request) error {
+	request.Header.Set("Authorization", auth.Key)
+	return nil
+}
+
+type BearerAuth struct {
+	Token string
+}
+
+func (auth BearerAuth) Authenticate(request *http.Request) error {
+	request.Header.Set("Authorization", "Bearer "+auth.Token)
+	return nil
+}
+
+// HMAC-SHA256 signature over the request, the string to sign is:
+//
+//	METHOD \n path \n sorted query \n hex SHA-256 of the body \n Date header
+//
+// sent as: Authorization: HMAC-SHA256 keyId=<id>, signature=<base64 signature>
+type HMACAuth struct {
+	KeyId  string
+	Secret string
+}
+
+func (auth HMACAuth) Authenticate(request *http.Request) error {
+	bodyHash, err := requestBodyHash(request)
+	if err != nil {
+		return err
+	}
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	request.Header.Set("Date", date)
+	request.Header.Set("X-Content-SHA256", bodyHash)
+
+	stringToSign := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		bodyHash,
+		date,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(auth.Secret))
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	request.Header.Set("Authorization", fmt.Sprintf("HMAC-SHA256 keyId=%s, signature=%s", auth.KeyId, signature))
+	return nil
+}
+
+// Hashes the body through a separate GetBody reader, so that the body to be sent stays unread
+func requestBodyHash(request *http.Request) (string, error) {
+	hash := sha256.New()
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody == nil {
+			return "", errors.New("request body cannot be read twice for signing")
+		}
+		body, err := request.GetBody()
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Whether the upload body is read an extra time for the signature
+func authReadsBody() bool {
+	return authScheme == AuthHMAC
+}
+
+// tlsConfig is the NumerX one, the token endpoint gets it without the server name override
+func newAuthenticator(tlsConfig *tls.Config) (Authenticator, error) {
+	switch authScheme {
+	case AuthStatic:
+		return StaticKeyAuth{Key: authorizationKey}, nil
+	case AuthBearer:
+		if authorizationKey == "" {
+			return nil, errors.New("bearer auth needs the token in -a")
+		}
+		return BearerAuth{Token: authorizationKey}, nil
+	case AuthHMAC:
+		if hmacKeyId == "" || hmacSecret == "" {
+			return nil, errors.New("hmac auth needs -hmac-key-id and -hmac-secret")
+		}
+		return HMACAuth{KeyId: hmacKeyId, Secret: hmacSecret}, nil
+	case AuthOAuth2:
+		tokenTLSConfig := tlsConfig.Clone()
+		tokenTLSConfig.ServerName = ""
+		client, err := newHTTPClient(tokenTLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := newOAuth2Auth(client)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	}
+	return nil, fmt.Errorf("unknown auth scheme %q, valid values are %s, %s, %s, %s", authScheme, AuthStatic, AuthHMAC, AuthBearer, AuthOAuth2)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	bandwidthLimit    byteRate
+	bandwidthSchedule bandwidthWindows
+)
+
+// Bytes (per second for rates) from 500K, 10M, 1G: binary multiples, optional trailing B or /s
+type byteRate int64
+
+func (r *byteRate) String() string {
+	if r == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*r), 10)
+}
+
+func (r *byteRate) Set(value string) error {
+	rate, err := parseByteRate(value)
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
+func parseByteRate(value string) (byteRate, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "/S")
+	s = strings.TrimSuffix(s, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	number, err := strconv.ParseFloat(s, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("bad rate %q, expected e.g. 500K, 10M", value)
+	}
+	return byteRate(number * float64(multiplier)), nil
+}
+
+// Time of day window with its own rate, may wrap around midnight: 22:00-06:00
+type bandwidthWindow struct {
+	from, to time.Duration
+	rate     byteRate
+}
+
+// Comma-separated windows like 09:00-18:00=2M,18:00-21:00=10M; outside of them -bandwidth applies
+type bandwidthWindows []bandwidthWindow
+
+func (w *bandwidthWindows) String() string {
+	if w == nil {
+		return ""
+	}
+	parts := []string{}
+	for _, window := range *w {
+		parts = append(parts, fmt.Sprintf("%s-%s=%d", clock(window.from), clock(window.to), window.rate))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (w *bandwidthWindows) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		times := strings.SplitN(parts[0], "-", 2)
+		if len(parts) != 2 || len(times) != 2 {
+			return fmt.Errorf("bad schedule entry %q, expected HH:MM-HH:MM=rate", entry)
+		}
+		from, err := parseClock(times[0])
+		if err != nil {
+			return err
+		}
+		to, err := parseClock(times[1])
+		if err != nil {
+			return err
+		}
+		rate, err := parseByteRate(parts[1])
+		if err != nil {
+			return err
+		}
+		*w = append(*w, bandwidthWindow{from: from, to: to, rate: rate})
+	}
+	return nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Upload rate at the local time of day: the first matching window, or -bandwidth
+func currentBandwidth(now time.Time) byteRate {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	sinceMidnight := now.Sub(midnight)
+	for _, window := range bandwidthSchedule {
+		if window.from <= window.to {
+			if sinceMidnight >= window.from && sinceMidnight < window.to {
+				return window.rate
+			}
+		} else if sinceMidnight >= window.from || sinceMidnight < window.to {
+			return window.rate
+		}
+	}
+	return bandwidthLimit
+}
+
+func bandwidthLimited() bool {
+	return bandwidthLimit > 0 || len(bandwidthSchedule) > 0
+}
+
+// One bucket for the bytes of all concurrent uploads, its rate follows the schedule
+var uploadBandwidth = struct {
+	sync.Mutex
+	bucket *TokenBucket
+	rate   byteRate
+}{}
+
+// The bucket for the current rate, nil if unlimited right now
+func bandwidthBucket() *TokenBucket {
+	rate := currentBandwidth(time.Now())
+	if rate <= 0 {
+		return nil
+	}
+
+	uploadBandwidth.Lock()
+	defer uploadBandwidth.Unlock()
+
+	if uploadBandwidth.bucket == nil || uploadBandwidth.rate != rate {
+		// A second worth of bytes at most in one go
+		uploadBandwidth.bucket = NewTokenBucket(float64(rate), int(rate))
+		uploadBandwidth.rate = rate
+	}
+	return uploadBandwidth.bucket
+}
+
+type throttledReader struct {
+	io.ReadCloser
+}
+
+// Reads at most a burst worth of bytes, then waits until the bucket allows them
+func (r throttledReader) Read(p []byte) (int, error) {
+	bucket := bandwidthBucket()
+	if bucket == nil {
+		return r.ReadCloser.Read(p)
+	}
+	if len(p) > int(bucket.burst) {
+		p = p[:int(bucket.burst)]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		bucket.WaitN(n)
+	}
+	return n, err
+}
+
+// Throttles request bodies, that is the uploads, to the -bandwidth limits
+type throttledTransport struct {
+	base http.RoundTripper
+}
+
+func (t throttledTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return t.base.RoundTrip(request)
+	}
+	throttled := *request
+	throttled.Body = throttledReader{request.Body}
+	return t.base.RoundTrip(&throttled)
+}
+
+// Wraps the client's transport, if any bandwidth limit is set
+func throttleUploads(client *http.Client) {
+	if bandwidthLimited() {
+		client.Transport = throttledTransport{client.Transport}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  byteRate
+	}{
+		{"0", 0},
+		{"1000", 1000},
+		{"500K", 500 << 10},
+		{"500k", 500 << 10},
+		{"10M", 10 << 20},
+		{"10MB", 10 << 20},
+		{"10MB/s", 10 << 20},
+		{"1.5G", 3 << 29},
+		{" 2M ", 2 << 20},
+		{"100B", 100},
+	}
+	for _, test := range tests {
+		got, err := parseByteRate(test.value)
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %d, want %d", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "fast", "-1M", "10T", "M"} {
+		if _, err := parseByteRate(value); err == nil {
+			t.Errorf("%q: no error", value)
+		}
+	}
+}
+
+func TestBandwidthSchedule(t *testing.T) {
+	var schedule bandwidthWindows
+	if err := schedule.Set("09:00-18:00=2M, 22:00-06:00=0"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := schedule.String(), "09:00-18:00=2097152,22:00-06:00=0"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	bandwidthSchedule = schedule
+	bandwidthLimit = 10 << 20
+	defer func() {
+		bandwidthSchedule = nil
+		bandwidthLimit = 0
+	}()
+
+	tests := []struct {
+		clock string
+		want  byteRate
+	}{
+		{"08:59", 10 << 20},
+		{"09:00", 2 << 20},
+		{"17:59", 2 << 20},
+		{"18:00", 10 << 20},
+		{"21:59", 10 << 20},
+		{"22:00", 0},
+		{"00:00", 0},
+		{"05:59", 0},
+		{"06:00", 10 << 20},
+	}
+	for _, test := range tests {
+		now, _ := time.ParseInLocation("2006-01-02 15:04", "2016-06-10 "+test.clock, time.Local)
+		if got := currentBandwidth(now); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.clock, got, test.want)
+		}
+	}
+}
+
+func TestBandwidthScheduleErrors(t *testing.T) {
+	for _, value := range []string{"09:00=2M", "09:00-18:00", "9am-18:00=2M", "09:00-25:00=2M", "09:00-18:00=fast"} {
+		var schedule bandwidthWindows
+		if err := schedule.Set(value); err == nil {
+			t.Errorf("%q: no error", value)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+)
+
+var inFlightBytes byteRate
+
+// Limits the total size of the files being uploaded at once, next to the -c number of files.
+// A file bigger than the whole budget is uploaded alone
+type ByteBudget struct {
+	sync.Mutex
+	cond     *sync.Cond
+	limit    int64
+	inFlight int64
+}
+
+// nil, that is unlimited, for limit <= 0
+func NewByteBudget(limit int64) *ByteBudget {
+	if limit <= 0 {
+		return nil
+	}
+	budget := &ByteBudget{limit: limit}
+	budget.cond = sync.NewCond(&budget.Mutex)
+	return budget
+}
+
+// Blocks until the size fits into the budget
+func (b *ByteBudget) Acquire(size int64) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	for b.inFlight > 0 && b.inFlight+size > b.limit {
+		b.cond.Wait()
+	}
+	b.inFlight += size
+}
+
+func (b *ByteBudget) Release(size int64) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	b.inFlight -= size
+	b.Unlock()
+	b.cond.Broadcast()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Files waiting for the budget in their own goroutines, as the uploads do:
+// the small ones behind a big one that does not fit go ahead of it
+func TestByteBudgetSmallFilesOvertake(t *testing.T) {
+	budget := NewByteBudget(100)
+	budget.Acquire(60) // big1 uploading
+
+	var lock sync.Mutex
+	started := []string{}
+	var wg sync.WaitGroup
+	for _, file := range []struct {
+		name string
+		size int64
+	}{{"big2", 60}, {"small1", 10}, {"small2", 10}, {"small3", 10}} {
+		wg.Add(1)
+		go func(name string, size int64) {
+			defer wg.Done()
+			budget.Acquire(size)
+			lock.Lock()
+			started = append(started, name)
+			lock.Unlock()
+			if name != "big2" {
+				budget.Release(size)
+			}
+		}(file.name, file.size)
+		// keep the discovery order
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	waitFor := func(count int) []string {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			lock.Lock()
+			got := append([]string(nil), started...)
+			lock.Unlock()
+			if len(got) >= count || time.Now().After(deadline) {
+				return got
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	got := waitFor(3)
+	if len(got) != 3 {
+		t.Fatalf("small files did not start while big2 waits: %v", got)
+	}
+	for _, name := range got {
+		if name == "big2" {
+			t.Fatalf("big2 started before big1 finished: %v", got)
+		}
+	}
+
+	budget.Release(60) // big1 done
+	if got = waitFor(4); len(got) != 4 || got[3] != "big2" {
+		t.Fatalf("big2 did not start after big1: %v", got)
+	}
+	wg.Wait()
+	budget.Release(60)
+}
+
+func TestByteBudget(t *testing.T) {
+	unlimited := NewByteBudget(0)
+	unlimited.Acquire(1 << 40)
+	unlimited.Release(1 << 40)
+
+	budget := NewByteBudget(100)
+	// A file bigger than the whole budget goes alone
+	budget.Acquire(500)
+	acquired := make(chan bool)
+	go func() {
+		budget.Acquire(1)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired next to a file over the budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	budget.Release(500)
+	select {
+	case <-acquired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("not acquired after the release")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+const defaultRequestIdHeader = "X-Request-ID"
+
+var requestIdHeader string
+
+// Random UUID (version 4) to correlate an upload attempt and the status checks of its job with the server logs
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Sets the -request-id-header, unless it is turned off
+func setRequestId(request *http.Request, requestId string) {
+	if requestIdHeader != "" && requestId != "" {
+		request.Header.Set(requestIdHeader, requestId)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repeatable, comma-separated list of glob patterns
+type patternList []string
+
+func (p *patternList) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %v", pattern, err)
+		}
+		*p = append(*p, pattern)
+	}
+	return nil
+}
+
+type SymlinkRule string
+
+const (
+	// Symlinks are not processed at all
+	SymlinkSkip SymlinkRule = "skip"
+	// Symlinks to regular files are processed, symlinked directories are never descended into
+	SymlinkFiles SymlinkRule = "files"
+)
+
+var (
+	includePatterns patternList
+	excludePatterns patternList
+	maxDepth        int
+	symlinkRule     SymlinkRule
+)
+
+// Temp file name patterns left behind by editors and partial transfers
+var tempFilePatterns = []string{"*~", "~$*", ".#*", "*.tmp", "*.temp", "*.part", "*.partial", "*.swp", "*.crdownload"}
+
+func validateSymlinkRule(rule string) bool {
+	switch SymlinkRule(rule) {
+	case SymlinkSkip, SymlinkFiles:
+		return true
+	}
+	fmt.Println("Wrong symlinks parameter value provided: ", rule)
+	fmt.Printf("Valid values are: %s, %s\n", SymlinkSkip, SymlinkFiles)
+	return false
+}
+
+// filepath.Walk callback decision for a path under the working directory:
+// whether to take the file and filepath.SkipDir for directories to prune
+func scanFilter(path string, f os.FileInfo) (bool, error) {
+	if path == dirName {
+		return false, nil
+	}
+
+	rel, err := filepath.Rel(dirName, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+	depth := strings.Count(rel, "/")
+
+	if f.IsDir() {
+		if isDispositionDir(path) || isHiddenFile(f.Name()) || matchesAny(excludePatterns, rel) ||
+			(maxDepth >= 0 && depth >= maxDepth) {
+			return false, filepath.SkipDir
+		}
+		return false, nil
+	}
+
+	if f.Mode()&os.ModeSymlink != 0 {
+		if symlinkRule != SymlinkFiles {
+			fileLog(path).Debugf("Skipping symlink")
+			return false, nil
+		}
+		target, err := os.Stat(path)
+		if err != nil || !target.Mode().IsRegular() {
+			fileLog(path).Debugf("Skipping symlink, not pointing to a regular file")
+			return false, nil
+		}
+	} else if !f.Mode().IsRegular() {
+		return false, nil
+	}
+
+	if !(isCsvFile(path) || isCompressedCsvFile(path)) || isHiddenFile(f.Name()) || isTempFile(f.Name()) {
+		return false, nil
+	}
+
+	if len(includePatterns) > 0 && !matchesAny(includePatterns, rel) {
+		return false, nil
+	}
+
+	return !matchesAny(excludePatterns, rel), nil
+}
+
+// Patterns with a slash are matched against the path relative to the working directory,
+// the others against the base name only. Matching is case-insensitive.
+func matchesAny(patterns patternList, rel string) bool {
+	rel = strings.ToLower(rel)
+	base := rel[strings.LastIndex(rel, "/")+1:]
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(filepath.ToSlash(pattern))
+		name := base
+		if strings.Contains(pattern, "/") {
+			name = rel
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isHiddenFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+func isTempFile(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range tempFilePatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
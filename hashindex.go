@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultIndexFile = "numerxdatapusher.index.json"
+
+// An entry of the local uploads index: the job that successfully indexed the content
+type IndexEntry struct {
+	JobId    string    `json:"jobId"`
+	Filename string    `json:"filename"`
+	Indexed  time.Time `json:"indexed"`
+}
+
+// Local index of uploaded content: data type -> SHA-256 of the content -> entry
+type HashIndex struct {
+	sync.Mutex
+	path    string
+	Entries map[RQTypeParam]map[string]IndexEntry `json:"entries"`
+}
+
+var hashIndex *HashIndex
+
+// Loads the index from the path, missing file means an empty index
+func loadHashIndex(path string) (*HashIndex, error) {
+	index := &HashIndex{
+		path:    path,
+		Entries: make(map[RQTypeParam]map[string]IndexEntry),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(content, index); err != nil {
+		return nil, err
+	}
+	if index.Entries == nil {
+		index.Entries = make(map[RQTypeParam]map[string]IndexEntry)
+	}
+	return index, nil
+}
+
+// Returns the entry for the content hash, if it has been indexed for the data type
+func (index *HashIndex) Lookup(rqType RQTypeParam, hash string) (IndexEntry, bool) {
+	index.Lock()
+	defer index.Unlock()
+
+	entry, ok := index.Entries[rqType][hash]
+	return entry, ok
+}
+
+// Records the content hash as indexed by the job and saves the index
+func (index *HashIndex) Record(rqType RQTypeParam, hash string, entry IndexEntry) error {
+	index.Lock()
+	defer index.Unlock()
+
+	if index.Entries[rqType] == nil {
+		index.Entries[rqType] = make(map[string]IndexEntry)
+	}
+	index.Entries[rqType][hash] = entry
+
+	return index.save()
+}
+
+// Writes to a temp file first, so that an interrupted run does not corrupt the index
+func (index *HashIndex) save() error {
+	content, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(index.path), filepath.Base(index.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), index.path)
+}
+
+// SHA-256 of the decompressed input contents, hex encoded
+func inputHash(input InputFile) (string, error) {
+	file, err := input.OpenContent()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
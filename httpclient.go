@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	requestTimeout        time.Duration
+	proxyUrl              string
+)
+
+// Shared by the uploads and the status checks, so that keep-alive connections are reused
+var httpClient *http.Client
+
+// Builds a client with the -proxy and timeouts settings, idle connections are sized to the -c concurrency.
+// tlsConfig is for the NumerX service only, nil for the default settings
+func newHTTPClient(tlsConfig *tls.Config) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if proxyUrl != "" {
+		parsed, err := url.Parse(proxyUrl)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("proxy must be an http or https URL: %s", proxyUrl)
+		}
+		proxy = http.ProxyURL(parsed)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          2 * concurrency,
+		MaxIdleConnsPerHost:   concurrency,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   requestTimeout,
+	}, nil
+}
+
+// Sends the request to the NumerX service within the rate limits for its kind.
+// After a 401 with a refreshable token the request is retried once with a fresh one
+func sendRequest(kind RequestKind, request *http.Request) (*http.Response, error) {
+	waitForRequestSlot(kind)
+	resp, err := httpClient.Do(request)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	refresher, ok := authenticator.(RefreshableAuthenticator)
+	if !ok {
+		return resp, nil
+	}
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody == nil {
+			return resp, nil
+		}
+		body, err := request.GetBody()
+		if err != nil {
+			// the body cannot be sent again, report the 401 as is
+			return resp, nil
+		}
+		request.Body = body
+	}
+	resp.Body.Close()
+
+	logger.Debugf("Got 401, retrying with a fresh token: %v", request.URL)
+	refresher.Invalidate(request)
+	if err = authenticator.Authenticate(request); err != nil {
+		return nil, err
+	}
+	waitForRequestSlot(kind)
+	return httpClient.Do(request)
+}
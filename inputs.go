@@ -0,0 +1,232 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+type Compression string
+
+const (
+	NoCompression Compression = ""
+	Gzip          Compression = "gzip"
+	Bzip2         Compression = "bzip2"
+	Zip           Compression = "zip"
+)
+
+// Compressed file extensions, the CSV extension is expected in front of all but .zip
+var compressionExt = map[string]Compression{
+	".gz":   Gzip,
+	".gzip": Gzip,
+	".bz2":  Bzip2,
+	".zip":  Zip,
+}
+
+// One upload job input: a file on disk, a CSV entry within a zip archive, or stdin/named pipe
+type InputFile struct {
+	// Name in logs and reports: the path, archive.zip!entry.csv, or the stream label
+	Name        string
+	Path        string
+	Entry       string
+	Compression Compression
+	// Stdin or a named pipe, Path is its spool file if any
+	Stream bool
+	stream *oneShotReader
+	// Object key for inputs from S3, Path is its spool file if any
+	S3Key string
+	// Size of the S3 object or of the uncompressed zip entry, 0 if not known
+	Size int64
+}
+
+func newInputFile(path string) InputFile {
+	return InputFile{
+		Name:        path,
+		Path:        path,
+		Compression: compressionOf(path),
+	}
+}
+
+// Compression of the input file by its extension: file.csv.gz, file.csv.bz2, file.zip
+func compressionOf(fileName string) Compression {
+	lower := strings.ToLower(fileName)
+	ext := path.Ext(lower)
+	compression, ok := compressionExt[ext]
+	if !ok {
+		return NoCompression
+	}
+	if compression == Zip || strings.HasSuffix(strings.TrimSuffix(lower, ext), "."+csvExt) {
+		return compression
+	}
+	return NoCompression
+}
+
+func isCompressedCsvFile(fileName string) bool {
+	return compressionOf(fileName) != NoCompression
+}
+
+// Whether the file is sent as is with Content-Encoding: gzip, instead of decompressing it locally
+func (input InputFile) forwardGzip() bool {
+	return input.Compression == Gzip && gzipEncoding
+}
+
+// Content-Encoding header of the upload, if any
+func (input InputFile) ContentEncoding() string {
+	if input.forwardGzip() {
+		return "gzip"
+	}
+	return ""
+}
+
+// Size of the input for the in-flight bytes budget: the upload body size if known,
+// the compressed size otherwise, 0 for streams that are not spooled
+func (input InputFile) UploadSize() int64 {
+	if size := input.ContentLength(); size >= 0 {
+		return size
+	}
+	if input.Size > 0 {
+		return input.Size
+	}
+	if input.Path == "" {
+		return 0
+	}
+	info, err := os.Stat(input.Path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Size of the upload body, -1 if it is only known after decompression
+func (input InputFile) ContentLength() int64 {
+	if input.Compression != NoCompression && !input.forwardGzip() {
+		return -1
+	}
+	if input.S3Key != "" {
+		return input.Size
+	}
+	if input.Path == "" {
+		return -1
+	}
+	info, err := os.Stat(input.Path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// Opens the input for reading the upload body, decompressing on the fly
+func (input InputFile) Open() (io.ReadCloser, error) {
+	return input.open(!input.forwardGzip())
+}
+
+// Opens the decompressed content regardless of -gzip-encoding, for content hashing
+func (input InputFile) OpenContent() (io.ReadCloser, error) {
+	return input.open(true)
+}
+
+func (input InputFile) open(decompress bool) (io.ReadCloser, error) {
+	if input.Compression == Zip {
+		return openZipEntry(input.Path, input.Entry)
+	}
+
+	var file io.ReadCloser
+	var err error
+	if input.stream != nil {
+		file, err = input.stream.take()
+	} else if input.S3Key != "" && input.Path == "" {
+		file, err = s3Client.GetObject(input.S3Key)
+	} else {
+		file, err = os.Open(input.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !decompress {
+		return file, nil
+	}
+
+	switch input.Compression {
+	case Gzip:
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return readCloser{reader, func() error {
+			reader.Close()
+			return file.Close()
+		}}, nil
+	case Bzip2:
+		return readCloser{bzip2.NewReader(file), file.Close}, nil
+	}
+	return file, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error {
+	return rc.close()
+}
+
+func openZipEntry(archive string, entry string) (io.ReadCloser, error) {
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zipReader.File {
+		if f.Name != entry {
+			continue
+		}
+		reader, err := f.Open()
+		if err != nil {
+			zipReader.Close()
+			return nil, err
+		}
+		return readCloser{reader, func() error {
+			reader.Close()
+			return zipReader.Close()
+		}}, nil
+	}
+	zipReader.Close()
+	return nil, fmt.Errorf("entry %s not found in %s", entry, archive)
+}
+
+// Expands a zip archive to one input per CSV entry, other files are taken as is
+func expandInput(fileName string) ([]InputFile, error) {
+	input := newInputFile(fileName)
+	if input.Compression != Zip {
+		return []InputFile{input}, nil
+	}
+
+	zipReader, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+
+	inputs := []InputFile{}
+	for _, f := range zipReader.File {
+		base := path.Base(f.Name)
+		if f.FileInfo().IsDir() || !isCsvFile(f.Name) || isHiddenFile(base) || isTempFile(base) ||
+			strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		inputs = append(inputs, InputFile{
+			Name:        fileName + "!" + f.Name,
+			Path:        fileName,
+			Entry:       f.Name,
+			Compression: Zip,
+			Size:        int64(f.UncompressedSize64),
+		})
+	}
+	return inputs, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+type JobState string
+
+const (
+	JobPending JobState = "pending" // no final status yet
+	JobIndexed JobState = "indexed"
+	JobFailed  JobState = "failed"
+)
+
+// Statuses a step is reported failed with
+var failureStatuses = map[string]bool{
+	string(Failure): true,
+	"failure":       true,
+}
+
+// The step pipeline of a data type as a state machine, fed with the status entries in time order.
+// Each entry moves the job to its step: the job is indexed when the last step succeeds and failed
+// when a step fails; a newer entry of any step, e.g. when the server processes the file again,
+// makes it pending or final again. Unknown steps leave the state as is, unknown statuses make the job pending
+type stepMachine struct {
+	steps []string
+	state JobState
+	entry NumerXStatusResponse // the entry the state comes from
+}
+
+func newStepMachine(rqType RQType) *stepMachine {
+	raw, parsed, index := pipelineSteps(rqType)
+	return &stepMachine{
+		steps: []string{raw, parsed, index},
+		state: JobPending,
+	}
+}
+
+func (m *stepMachine) stepIndex(step string) int {
+	for i, s := range m.steps {
+		if s == step {
+			return i
+		}
+	}
+	return -1
+}
+
+// Applies the entry, false if its step or status is unknown
+func (m *stepMachine) apply(entry NumerXStatusResponse) bool {
+	step := m.stepIndex(entry.Step)
+	if step < 0 {
+		return false
+	}
+	m.entry = entry
+
+	switch {
+	case entry.Status == string(Success) && step == len(m.steps)-1:
+		m.state = JobIndexed
+	case entry.Status == string(Success):
+		m.state = JobPending
+	case failureStatuses[entry.Status]:
+		m.state = JobFailed
+	default:
+		m.state = JobPending
+		return false
+	}
+	return true
+}
+
+// Unknown steps and statuses already logged, to log each once per run
+var loggedUnknown sync.Map
+
+// The status entries in the order they happened: by timestamp, then for entries without a timestamp
+// or with the same one, by the pipeline step and a failure after a success of the same step.
+// The server order is no help, it lists the newest first
+func orderedByTime(status []NumerXStatusResponse) []NumerXStatusResponse {
+	machine := newStepMachine(requestType)
+	ordered := make([]NumerXStatusResponse, len(status))
+	copy(ordered, status)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		if stepA, stepB := machine.stepIndex(a.Step), machine.stepIndex(b.Step); stepA != stepB {
+			return stepA < stepB
+		}
+		return statusRank(a.Status) < statusRank(b.Status)
+	})
+	return ordered
+}
+
+// Order of the statuses of one step at the same time: unknown, success, failure
+func statusRank(status string) int {
+	switch {
+	case failureStatuses[status]:
+		return 2
+	case status == string(Success):
+		return 1
+	}
+	return 0
+}
+
+// The job state from its status entries ordered by timestamp, and the latest known entry
+func evaluateStatus(job JobType, status []NumerXStatusResponse) (JobState, NumerXStatusResponse) {
+	machine := newStepMachine(requestType)
+	for _, entry := range orderedByTime(status) {
+		if machine.apply(entry) {
+			continue
+		}
+		key := entry.Step + "=" + entry.Status
+		if _, logged := loggedUnknown.LoadOrStore(key, true); !logged {
+			jobLog(job).Warnf("Unknown step or status for %s: step %q, status %q", param_RQ_T, entry.Step, entry.Status)
+		}
+	}
+	return machine.state, machine.entry
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluateStatus(t *testing.T) {
+	requestType = RQ_MetaChanMap
+	at := func(minute int) time.Time {
+		return time.Date(2016, 6, 10, 20, minute, 0, 0, time.UTC)
+	}
+	entry := func(step EventProcessingSteps, status string, minute int) NumerXStatusResponse {
+		return NumerXStatusResponse{Step: string(step), Status: status, Timestamp: at(minute)}
+	}
+
+	tests := []struct {
+		name       string
+		status     []NumerXStatusResponse
+		state      JobState
+		latestStep EventProcessingSteps
+	}{
+		{"no entries", nil, JobPending, ""},
+		{"raw done", []NumerXStatusResponse{
+			entry(RawMetaData, "success", 1),
+		}, JobPending, RawMetaData},
+		{"indexed, newest first", []NumerXStatusResponse{
+			entry(IndexMetaData, "success", 3),
+			entry(ParsedMetaData, "success", 2),
+			entry(RawMetaData, "success", 1),
+		}, JobIndexed, IndexMetaData},
+		{"failed step", []NumerXStatusResponse{
+			entry(RawMetaData, "success", 1),
+			entry(ParsedMetaData, "failed", 2),
+		}, JobFailed, ParsedMetaData},
+		{"failure spelling", []NumerXStatusResponse{
+			entry(RawMetaData, "failure", 1),
+		}, JobFailed, RawMetaData},
+		{"stale failure then success", []NumerXStatusResponse{
+			entry(IndexMetaData, "success", 5),
+			entry(ParsedMetaData, "failed", 2),
+			entry(ParsedMetaData, "success", 4),
+			entry(RawMetaData, "success", 1),
+		}, JobIndexed, IndexMetaData},
+		{"success then newer failure", []NumerXStatusResponse{
+			entry(IndexMetaData, "success", 3),
+			entry(RawMetaData, "failed", 6),
+		}, JobFailed, RawMetaData},
+		{"reprocessed after indexing", []NumerXStatusResponse{
+			entry(IndexMetaData, "success", 3),
+			entry(RawMetaData, "success", 6),
+		}, JobPending, RawMetaData},
+		{"unknown step ignored", []NumerXStatusResponse{
+			entry(IndexMetaData, "success", 3),
+			entry("archived", "success", 4),
+		}, JobIndexed, IndexMetaData},
+		{"unknown status", []NumerXStatusResponse{
+			entry(RawMetaData, "success", 1),
+			entry(ParsedMetaData, "queued", 2),
+		}, JobPending, ParsedMetaData},
+		{"other data type steps", []NumerXStatusResponse{
+			entry(IndexEventData, "success", 3),
+		}, JobPending, ""},
+	}
+
+	for _, test := range tests {
+		state, latest := evaluateStatus(JobType{JobId: "test"}, test.status)
+		if state != test.state || latest.Step != string(test.latestStep) {
+			t.Errorf("%s: got %s at %q, want %s at %q", test.name, state, latest.Step, test.state, test.latestStep)
+		}
+	}
+}
+
+func TestEvaluateStatusSameTime(t *testing.T) {
+	requestType = RQ_MetaChanMap
+	same := time.Date(2016, 6, 10, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status []NumerXStatusResponse
+		state  JobState
+	}{
+		// The sample payload of the server, newest first, without timestamps
+		{"newest first without timestamps", []NumerXStatusResponse{
+			{Step: string(IndexMetaData), Status: "success"},
+			{Step: string(ParsedMetaData), Status: "success"},
+			{Step: string(RawMetaData), Status: "success"},
+		}, JobIndexed},
+		{"newest first, same timestamp", []NumerXStatusResponse{
+			{Step: string(IndexMetaData), Status: "success", Timestamp: same},
+			{Step: string(ParsedMetaData), Status: "success", Timestamp: same},
+			{Step: string(RawMetaData), Status: "success", Timestamp: same},
+		}, JobIndexed},
+		{"failure and success of a step, same timestamp", []NumerXStatusResponse{
+			{Step: string(ParsedMetaData), Status: "failed", Timestamp: same},
+			{Step: string(ParsedMetaData), Status: "success", Timestamp: same},
+			{Step: string(RawMetaData), Status: "success", Timestamp: same},
+		}, JobFailed},
+		{"parsed without timestamp", []NumerXStatusResponse{
+			{Step: string(ParsedMetaData), Status: "success"},
+			{Step: string(RawMetaData), Status: "success"},
+		}, JobPending},
+	}
+
+	for _, test := range tests {
+		if state, _ := evaluateStatus(JobType{JobId: "test"}, test.status); state != test.state {
+			t.Errorf("%s: got %s, want %s", test.name, state, test.state)
+		}
+	}
+}
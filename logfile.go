@@ -0,0 +1,205 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logFileName  string
+	logMaxSize   byteRate
+	logMaxAge    time.Duration
+	logMaxFiles  int
+	logToStderr  bool
+	logRotations sync.WaitGroup
+)
+
+// Log file rotated by size and age: the current file is renamed with a timestamp suffix,
+// gzipped in the background, and only the newest -log-max-files rotated files are kept
+type RotatingFile struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+	file     *os.File
+	size     int64
+	opened   time.Time
+}
+
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxFiles int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		maxFiles: maxFiles,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Appends to an existing file, its age counts from now
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.size > 0 && r.due(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) due(next int64) bool {
+	if r.maxSize > 0 && r.size+next > r.maxSize {
+		return true
+	}
+	return r.maxAge > 0 && time.Since(r.opened) > r.maxAge
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	rotated := r.path + "." + time.Now().Format(collisionTimeFormat)
+	renameErr := os.Rename(r.path, rotated)
+	// Keep on writing to the current file, if it could not be renamed
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	logRotations.Add(1)
+	go func() {
+		defer logRotations.Done()
+		if err := compressFile(rotated); err != nil {
+			logger.Warnf("Could not compress rotated log file %s: %v", rotated, err)
+		}
+		r.prune()
+	}()
+	return nil
+}
+
+// Replaces the file with its .gz
+func compressFile(fileName string) error {
+	in, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(fileName + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileName + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(fileName)
+}
+
+// Removes the oldest rotated files above maxFiles, compressed or not
+func (r *RotatingFile) prune() {
+	if r.maxFiles <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+	rotated := map[string][]string{}
+	stamps := []string{}
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, r.path+"."), ".gz")
+		if _, err := time.Parse(collisionTimeFormat, stamp); err != nil {
+			continue
+		}
+		if rotated[stamp] == nil {
+			stamps = append(stamps, stamp)
+		}
+		rotated[stamp] = append(rotated[stamp], match)
+	}
+	// The timestamps sort in time order
+	sort.Strings(stamps)
+	for len(stamps) > r.maxFiles {
+		for _, fileName := range rotated[stamps[0]] {
+			if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+				logger.Warnf("Could not remove old log file %s: %v", fileName, err)
+			}
+		}
+		stamps = stamps[1:]
+	}
+}
+
+func (r *RotatingFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.file.Close()
+}
+
+var logFile *RotatingFile
+
+// Sends the logs to -log-file, next to stderr unless -log-stderr=false
+func openLogFile() error {
+	if logFileName == "" {
+		return nil
+	}
+	var err error
+	logFile, err = OpenRotatingFile(logFileName, int64(logMaxSize), logMaxAge, logMaxFiles)
+	return err
+}
+
+// Waits for the rotated files to be compressed
+func closeLogFile() {
+	if logFile == nil {
+		return
+	}
+	logRotations.Wait()
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logFile.Close()
+	logFile = nil
+}
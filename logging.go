@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelError LogLevel = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+	LevelTrace
+)
+
+var levelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+func (l LogLevel) String() string {
+	return levelNames[l]
+}
+
+func parseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("wrong log level %q, valid values are: %s", name, strings.Join(levelNames, ", "))
+}
+
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+var (
+	logLevelName string
+	logFormat    string
+	quiet        bool
+	maxBodyLog   int
+
+	logLevel = LevelInfo
+	// Level of the lines on stderr, the log file takes all of logLevel
+	stderrLevel = LevelInfo
+	logMutex    sync.Mutex
+)
+
+// Sets the level from -log-level, or else from -v and -quiet; stderr gets only warnings and errors
+// under the progress view, unless one of them is set
+func initLogging() error {
+	if logFormat != LogText && logFormat != LogJSON {
+		return fmt.Errorf("wrong log format %q, valid values are: %s, %s", logFormat, LogText, LogJSON)
+	}
+	switch {
+	case logLevelName != "":
+		level, err := parseLogLevel(logLevelName)
+		if err != nil {
+			return err
+		}
+		logLevel = level
+	case quiet:
+		logLevel = LevelError
+	case verbose:
+		logLevel = LevelDebug
+	}
+	stderrLevel = logLevel
+	if logLevelName == "" && !quiet && !verbose && progressEnabled() && isTerminal(os.Stderr) {
+		// The progress view takes the place of the info lines on the terminal
+		stderrLevel = LevelWarn
+	}
+	return openLogFile()
+}
+
+func logEnabled(level LogLevel) bool {
+	return level <= logLevel
+}
+
+// Context of log lines: the input file, its job, upload attempt and its correlation id; the data type is added to all lines
+type Logger struct {
+	file      string
+	jobId     string
+	requestId string
+	attempt   int
+}
+
+// Logger without context
+var logger Logger
+
+func fileLog(fileName string) Logger {
+	return Logger{file: fileName}
+}
+
+func jobLog(job JobType) Logger {
+	return Logger{file: job.Filename, jobId: job.JobId, requestId: job.RequestId}
+}
+
+func (l Logger) Request(requestId string) Logger {
+	l.requestId = requestId
+	return l
+}
+
+func (l Logger) Job(jobId string) Logger {
+	l.jobId = jobId
+	return l
+}
+
+// attempt counts from 1
+func (l Logger) Attempt(attempt int) Logger {
+	l.attempt = attempt
+	return l
+}
+
+func (l Logger) Errorf(format string, args ...interface{}) { l.logf(LevelError, format, args...) }
+func (l Logger) Warnf(format string, args ...interface{})  { l.logf(LevelWarn, format, args...) }
+func (l Logger) Infof(format string, args ...interface{})  { l.logf(LevelInfo, format, args...) }
+func (l Logger) Debugf(format string, args ...interface{}) { l.logf(LevelDebug, format, args...) }
+func (l Logger) Tracef(format string, args ...interface{}) { l.logf(LevelTrace, format, args...) }
+
+type logEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Message   string `json:"msg"`
+	File      string `json:"file"`
+	JobId     string `json:"job_id"`
+	RequestId string `json:"request_id"`
+	DataType  string `json:"data_type"`
+	Attempt   int    `json:"attempt"`
+}
+
+func (l Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	now := time.Now()
+	message := strings.TrimRight(fmt.Sprintf(format, args...), "\n ")
+
+	var line string
+	if logFormat == LogJSON {
+		encoded, _ := json.Marshal(logEntry{
+			Time:      now.Format(time.RFC3339Nano),
+			Level:     level.String(),
+			Message:   message,
+			File:      l.file,
+			JobId:     l.jobId,
+			RequestId: l.requestId,
+			DataType:  param_RQ_T,
+			Attempt:   l.attempt,
+		})
+		line = string(encoded)
+	} else {
+		line = fmt.Sprintf("%s %-5s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), message)
+		if l.file != "" {
+			line += fmt.Sprintf(" file=%q", l.file)
+		}
+		if l.jobId != "" {
+			line += " job=" + l.jobId
+		}
+		if l.requestId != "" {
+			line += " request=" + l.requestId
+		}
+		if l.attempt > 0 {
+			line += fmt.Sprintf(" attempt=%d", l.attempt)
+		}
+	}
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if logFile != nil {
+		logFile.Write([]byte(line + "\n"))
+	}
+	if (logFile == nil || logToStderr) && level <= stderrLevel {
+		log.Writer().Write([]byte(line + "\n"))
+	}
+}
+
+// Request and response bodies for trace logs, cut to -log-body bytes
+func truncateBody(body []byte) string {
+	if maxBodyLog <= 0 || len(body) <= maxBodyLog {
+		return string(body)
+	}
+	return fmt.Sprintf("%s... (%d bytes more)", body[:maxBodyLog], len(body)-maxBodyLog)
+}
+
+// Headers for trace logs, without the credentials
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range []string{"Authorization", "Proxy-Authorization"} {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "REDACTED")
+		}
+	}
+	return redacted
+}
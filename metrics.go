@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var metricsAddr string
+
+// Failure categories for the failed files counter
+const (
+	FailureRead       = "read"       // the input could not be read or hashed
+	FailureRequest    = "request"    // the upload request could not be built
+	FailurePost       = "post"       // the upload did not go through after all re-tries
+	FailureHttp       = "http"       // the upload got a non-200 response
+	FailureResponse   = "response"   // the upload response has no job id
+	FailureProcessing = "processing" // a processing step failed on the server
+	FailureStatus     = "status"     // the status checks failed
+	FailureNotFound   = "not-found"  // the server does not know the job id
+)
+
+// Minimal Prometheus text format registry: counters, gauges and histograms with labels
+type metric struct {
+	sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+var registry []*metric
+
+func newMetric(kind string, name string, help string, buckets []float64, labels ...string) *metric {
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	registry = append(registry, m)
+	return m
+}
+
+func (m *metric) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s := m.series[key]
+	if s == nil {
+		s = &series{labelValues: labelValues, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+// For counters and gauges
+func (m *metric) Add(delta float64, labelValues ...string) {
+	m.Lock()
+	m.get(labelValues).value += delta
+	m.Unlock()
+}
+
+func (m *metric) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *metric) Dec(labelValues ...string) {
+	m.Add(-1, labelValues...)
+}
+
+// For histograms
+func (m *metric) Observe(value float64, labelValues ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	s := m.get(labelValues)
+	for i, bound := range m.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (m *metric) write(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelString(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelString(s.labelValues, formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelString(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelString(s.labelValues, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelString(s.labelValues, ""), s.count)
+	}
+}
+
+func (m *metric) labelString(labelValues []string, le string) string {
+	pairs := []string{}
+	for i, label := range m.labels {
+		pairs = append(pairs, label+"="+strconv.Quote(labelValues[i]))
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+	bytesBuckets   = []float64{1 << 10, 16 << 10, 256 << 10, 1 << 20, 16 << 20, 256 << 20, 1 << 30, 4 << 30}
+	indexBuckets   = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200, 21600}
+
+	filesDiscovered = newMetric("counter", "numerx_files_discovered_total", "Files found to process", nil, "type")
+	filesPosted     = newMetric("counter", "numerx_files_posted_total", "Files accepted by the server with a job id", nil, "type")
+	filesIndexed    = newMetric("counter", "numerx_files_indexed_total", "Files indexed successfully", nil, "type")
+	filesFailed     = newMetric("counter", "numerx_files_failed_total", "Files failed, by failure category", nil, "type", "category")
+	postLatency     = newMetric("histogram", "numerx_post_duration_seconds", "Upload POST request latency", latencyBuckets, "type")
+	uploadedBytes   = newMetric("histogram", "numerx_uploaded_bytes", "Bytes sent per upload POST request", bytesBuckets, "type")
+	stepDuration    = newMetric("histogram", "numerx_time_to_step_seconds", "Time from the upload to the step completion, by the step timestamp", indexBuckets, "type", "step")
+	uploadsInFlight = newMetric("gauge", "numerx_uploads_in_flight", "Uploads in progress", nil, "type")
+	jobsPolling     = newMetric("gauge", "numerx_jobs_polling", "Submitted jobs waiting for their final status", nil, "type")
+	spansDropped    = newMetric("counter", "numerx_spans_dropped_total", "Trace spans dropped with the exporter buffer full", nil)
+)
+
+func metricsEnabled() bool {
+	return metricsAddr != ""
+}
+
+// Serves /metrics in the background for the rest of the run
+func startMetricsServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range registry {
+			m.write(w)
+		}
+	})
+
+	go func() {
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			logger.Errorf("Metrics endpoint failed: %v", err)
+		}
+	}()
+}
+
+// Steps already seen completed per job id, so that each is observed once
+var seenSteps = struct {
+	sync.Mutex
+	steps map[string]map[string]bool
+}{steps: make(map[string]map[string]bool)}
+
+// Observes the time from the upload to each step completed since the last check, by the step timestamp;
+// the time of the check is taken only if the server did not send one
+func observeSteps(job JobType, status []NumerXStatusResponse) {
+	seenSteps.Lock()
+	defer seenSteps.Unlock()
+
+	seen := seenSteps.steps[job.JobId]
+	if seen == nil {
+		seen = make(map[string]bool)
+		seenSteps.steps[job.JobId] = seen
+	}
+	for _, entry := range status {
+		if entry.Status != string(Success) || seen[entry.Step] {
+			continue
+		}
+		seen[entry.Step] = true
+		done := entry.Timestamp
+		if done.IsZero() {
+			done = time.Now()
+		}
+		if !done.Before(job.Posted) {
+			stepDuration.Observe(done.Sub(job.Posted).Seconds(), param_RQ_T, entry.Step)
+		}
+	}
+}
+
+// Drops the seen steps of a job with its final status
+func forgetSteps(job JobType) {
+	seenSteps.Lock()
+	delete(seenSteps.steps, job.JobId)
+	seenSteps.Unlock()
+}
+
+// Counts the bytes read from the upload body, the transport writes it from its own goroutine.
+// The count is observed once the transport closes the body, after the last write
+type countingReader struct {
+	io.ReadCloser
+	count  int64
+	closed sync.Once
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.count, int64(n))
+	progress.AddBytes(int64(n))
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	r.closed.Do(func() {
+		uploadedBytes.Observe(float64(atomic.LoadInt64(&r.count)), param_RQ_T)
+	})
+	return r.ReadCloser.Close()
+}
+
+// Measures the upload requests: latency and bytes sent
+type metricsTransport struct {
+	base http.RoundTripper
+}
+
+func (t metricsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method != "POST" {
+		return t.base.RoundTrip(request)
+	}
+
+	counted := *request
+	if request.Body != nil && request.Body != http.NoBody {
+		counted.Body = &countingReader{ReadCloser: request.Body}
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(&counted)
+	if err == nil {
+		postLatency.Observe(time.Since(start).Seconds(), param_RQ_T)
+	}
+	return resp, err
+}
+
+// Wraps the client's transport, if the metrics endpoint or the progress view is on
+func measureUploads(client *http.Client) {
+	if metricsEnabled() || progressEnabled() {
+		client.Transport = metricsTransport{client.Transport}
+	}
+}
@@ -1,39 +1,38 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// Creates a new file upload http request with optional extra params
-func newfileUploadRequest(uri string, resource string, params map[string]string, path string) (*http.Request, error) {
-	file, err := os.Open(path)
+// Creates a new file upload http request with optional extra params.
+// The input is streamed as the body, GetBody re-opens it for retries
+func newfileUploadRequest(uri string, resource string, params map[string]string, input InputFile) (*http.Request, error) {
+	body, err := input.Open()
 	if err != nil {
 		return nil, err
 	}
-	fileContents, err := ioutil.ReadAll(file)
-	if err != nil {
-		return nil, err
-	}
-	file.Close()
 
-	request, err := http.NewRequest("POST", uri+resource, bytes.NewBuffer([]byte(fileContents)))
+	request, err := http.NewRequest("POST", uri+resource, body)
 
 	if err != nil {
-		log.Println("Could not allocate new request object: ", err)
+		body.Close()
+		fileLog(input.Name).Errorf("Could not allocate new request object: %v", err)
 		return nil, err
 	}
 
+	request.ContentLength = input.ContentLength()
+	request.GetBody = input.Open
+
 	values := request.URL.Query()
 	for key, val := range params {
 		values.Add(key, val)
@@ -42,8 +41,15 @@ func newfileUploadRequest(uri string, resource string, params map[string]string,
 	request.URL.RawQuery = values.Encode()
 
 	request.Header.Add("Accept", "application/json")
-	request.Header.Add("Authorization", authorizationKey)
 	request.Header.Add("Content-Type", "text/csv")
+	if encoding := input.ContentEncoding(); encoding != "" {
+		request.Header.Add("Content-Encoding", encoding)
+	}
+
+	if err = authenticator.Authenticate(request); err != nil {
+		body.Close()
+		return nil, err
+	}
 
 	return request, err
 }
@@ -65,7 +71,10 @@ func fileUploadStatusRequest(uri string, resource string, params map[string]stri
 
 	request.Header.Add("Content-Type", "application/json")
 	request.Header.Add("Accept", "application/json")
-	request.Header.Add("Authorization", authorizationKey)
+
+	if err = authenticator.Authenticate(request); err != nil {
+		return nil, err
+	}
 
 	return request, nil
 }
@@ -146,6 +155,14 @@ var (
 	retryNumber      int
 	appName          string
 	timeout          time.Duration
+	retryWait        time.Duration
+	doneDir          string
+	failedDir        string
+	copyProcessed    bool
+	indexFileName    string
+	forceUpload      bool
+	gzipEncoding     bool
+	streamLabel      string
 )
 
 const (
@@ -153,20 +170,90 @@ const (
 	csvExt        = "csv"
 	TIMEOUT       = 1
 	RETRY_DEFAULT = 3
+	RETRY_WAIT    = 30 * time.Second
 )
 
-func init() {
+// Defines and parses the flags, in main so that go test can run with its own flags
+func parseFlags() {
 	initParams()
 
-	flagAuthorization := flag.String("a", "", "`Authorization key`")
+	flagAuthorization := flag.String("a", "", "`Authorization key`, the token for -auth bearer")
 	flagBaseUrl := flag.String("b", "", "`Base URL` for NumerXData service")
 	flagRQType := flag.String("t", string(param_RQ_Viewership), "`Request/data type`")
-	flagFileName := flag.String("f", "", "Input `filename` to process")
+	flagFileName := flag.String("f", "", "Input `filename` to process, - for stdin")
 	flagDirName := flag.String("d", "", "Working `directory` for input files, default extension *.csv")
 	flagConcurrency := flag.Int("c", 20, "The number of files to process `concurrent`ly")
-	flagVerbose := flag.Bool("v", true, "`Verbose`: outputs to the screen")
-	flagTimeout := flag.Int("s", TIMEOUT, "`Sleep time` in minutes")
+	flagVerbose := flag.Bool("v", false, "`Verbose`: debug logging, same as -log-level debug")
+	flag.BoolVar(&quiet, "quiet", false, "Log errors only, same as -log-level error")
+	flag.StringVar(&logLevelName, "log-level", "", "Log `level`: error, warn, info, debug, trace (with request and response bodies); default info")
+	flag.StringVar(&logFormat, "log-format", LogText, "Log `format`: text, or json - one object per line with file, job id, data type and attempt")
+	flag.StringVar(&requestIdHeader, "request-id-header", defaultRequestIdHeader, "`Header` to send the per-upload correlation id in, with the upload and its status checks; empty to not send it")
+	flag.StringVar(&progressMode, "progress", string(ProgressAuto), "Live progress `view`: auto - when stdout is a terminal, on, off; a terminal stderr gets only warnings and errors then, unless -log-level or -v is set")
+	flag.StringVar(&traceFileName, "trace-file", "", "`File` to write a trace per file to, as OTLP JSON spans one per line; - for stdout")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP traces `URL` to export the spans to as JSON, e.g. http://localhost:4318/v1/traces")
+	flag.StringVar(&logFileName, "log-file", "", "Log `file` to write to, next to stderr; rotated by -log-max-size and -log-max-age")
+	logMaxSize = 100 << 20
+	flag.Var(&logMaxSize, "log-max-size", "`Size` to rotate the log file at: 10M, 1G, 0 for no limit (default 100M)")
+	flag.DurationVar(&logMaxAge, "log-max-age", 24*time.Hour, "`Age` to rotate the log file at, 0 for no limit")
+	flag.IntVar(&logMaxFiles, "log-max-files", 7, "`Number` of rotated gzipped log files to keep, 0 to keep all")
+	flag.BoolVar(&logToStderr, "log-stderr", true, "Log to stderr too, when logging to -log-file")
+	flag.IntVar(&maxBodyLog, "log-body", 1024, "Max `bytes` of a request or response body to log at trace level, 0 for all")
+	pollInterval := durationValue{Duration: TIMEOUT * time.Minute}
+	flag.Var(&pollInterval, "s", "`Sleep time` between status checks: 30s, 2m, 1m30s, a plain number is minutes")
+	flag.Var(&initialDelay, "initial-delay", "`Time` before the first status check of a job, same syntax as -s, default is -s")
+	flag.DurationVar(&retryWait, "retry-wait", RETRY_WAIT, "`Time` to wait before re-trying a failed upload")
 	flagRetryNumber := flag.Int("r", RETRY_DEFAULT, "`Retry` number")
+	flagDoneDir := flag.String("done", "", "`Directory` to move successfully indexed files to")
+	flagFailedDir := flag.String("failed", "", "`Directory` to move failed files to")
+	flagCopy := flag.Bool("copy", false, "Copy files to done/failed directories instead of moving them")
+	flagIndexFile := flag.String("index", defaultIndexFile, "Local `index file` of already uploaded content hashes, empty to disable")
+	flagForce := flag.Bool("force", false, "Upload files even if their content is already indexed")
+	flag.Var(&includePatterns, "include", "Glob `patterns` of files to process, comma-separated or repeated")
+	flag.Var(&excludePatterns, "exclude", "Glob `patterns` of files and directories to skip, comma-separated or repeated")
+	flagMaxDepth := flag.Int("depth", -1, "Max `depth` of subdirectories to scan, -1 for unlimited")
+	flagNoRecurse := flag.Bool("norecurse", false, "Do not scan subdirectories, same as -depth 0")
+	flagLabel := flag.String("label", "stdin", "Job `name` in reports for stdin or named pipe input, its extension tells the compression")
+	flag.StringVar(&s3Location, "s3", "", "S3 `location` s3://bucket/prefix to take input files from, instead of -f/-d")
+	flag.StringVar(&s3Endpoint, "s3-endpoint", s3DefaultEndpoint, "S3-compatible service `URL`, e.g. http://localhost:9000 for MinIO")
+	flag.StringVar(&s3Region, "s3-region", s3DefaultRegion, "S3 `region` for request signing")
+	flag.StringVar(&s3AccessKey, "s3-access-key", "", "S3 access `key`, default from AWS_ACCESS_KEY_ID")
+	flag.StringVar(&s3SecretKey, "s3-secret-key", "", "S3 secret `key`, default from AWS_SECRET_ACCESS_KEY")
+	flag.BoolVar(&s3VirtualHost, "s3-virtual-host", false, "Use virtual-hosted style bucket.endpoint addressing instead of endpoint/bucket")
+	flag.StringVar(&s3SuccessTag, "s3-tag", "", "`key=value` tag to set on S3 objects after success")
+	flag.StringVar(&s3DonePrefix, "s3-done-prefix", "", "S3 key `prefix` to move objects under after success")
+	flag.StringVar(&s3FailedPrefix, "s3-failed-prefix", "", "S3 key `prefix` to move objects under after failure")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 30*time.Second, "`Timeout` for establishing connections")
+	flag.DurationVar(&tlsHandshakeTimeout, "tls-timeout", 10*time.Second, "`Timeout` for the TLS handshake")
+	flag.DurationVar(&responseHeaderTimeout, "header-timeout", 5*time.Minute, "`Timeout` for the response headers after the request is sent, 0 for none")
+	flag.DurationVar(&requestTimeout, "request-timeout", 0, "Overall request `timeout` including the upload, 0 for none")
+	flag.StringVar(&proxyUrl, "proxy", "", "HTTP/HTTPS proxy `URL`, default from HTTP_PROXY/HTTPS_PROXY")
+	flag.StringVar(&caBundleFile, "ca-cert", "", "PEM `file` with CA certificates to trust for the NumerX service, in addition to the system ones")
+	flag.StringVar(&clientCertFile, "client-cert", "", "PEM client certificate `file` for mutual TLS")
+	flag.StringVar(&clientKeyFile, "client-key", "", "PEM client private key `file` for mutual TLS")
+	flag.StringVar(&tlsMinVersion, "tls-min", "1.2", "Minimum TLS `version`: 1.0, 1.1, 1.2, 1.3")
+	flag.StringVar(&tlsServerName, "tls-server-name", "", "Server `name` to verify the NumerX certificate against, instead of the -b host")
+	flagAuthScheme := flag.String("auth", string(AuthStatic), "Auth `scheme`: static - Authorization header from -a, hmac - signed requests, bearer - token from -a, oauth2 - client credentials tokens")
+	flag.StringVar(&hmacKeyId, "hmac-key-id", "", "HMAC `key id` for -auth hmac")
+	flag.StringVar(&hmacSecret, "hmac-secret", "", "HMAC `secret` for -auth hmac")
+	flag.StringVar(&tokenUrl, "token-url", "", "OAuth2 token endpoint `URL` for -auth oauth2")
+	flag.StringVar(&clientId, "client-id", "", "OAuth2 client `id` for -auth oauth2")
+	flag.StringVar(&clientSecret, "client-secret", "", "OAuth2 client `secret` for -auth oauth2")
+	flag.StringVar(&tokenScope, "scope", "", "OAuth2 `scope` to request, if any")
+	flag.StringVar(&tokenAuthIn, "token-auth", "basic", "How the client credentials are sent to the token endpoint: `basic` header or form body")
+	flag.Float64Var(&requestsPerSecond, "rps", 0, "Max `requests per second` to the NumerX service, uploads and status checks together, 0 for unlimited")
+	flag.Float64Var(&uploadsPerSecond, "upload-rps", 0, "Max upload `requests per second`, 0 for unlimited")
+	flag.Float64Var(&statusPerSecond, "status-rps", 0, "Max status check `requests per second`, 0 for unlimited")
+	flag.IntVar(&requestsBurst, "burst", 1, "`Number` of requests allowed at once above the -rps limits")
+	flag.IntVar(&statusRetries, "status-retries", 5, "`Number` of status check errors in a row (5xx, 429, timeouts) before the job is failed")
+	flag.IntVar(&pollConcurrency, "pollers", 5, "The `number` of status checks to run concurrently")
+	flag.DurationVar(&pollMaxInterval, "poll-max", 10*time.Minute, "Max `interval` between status checks of a job, 0 for no limit")
+	flag.Float64Var(&pollGrowth, "poll-growth", 1.5, "`Factor` the interval between status checks of a job grows by after each check, 1 for a fixed interval")
+	flag.Var(&bandwidthLimit, "bandwidth", "Max upload `rate` in bytes per second for all uploads together: 500K, 10M, 0 for unlimited")
+	flag.Var(&bandwidthSchedule, "bandwidth-schedule", "Upload rates by local time of `day`, e.g. 09:00-18:00=2M,22:00-06:00=0; -bandwidth applies outside of them")
+	flag.Var(&inFlightBytes, "inflight-bytes", "Max total `size` of the files being uploaded at once: 500M, 2G, 0 for unlimited; a bigger file goes alone")
+	flag.StringVar(&metricsAddr, "metrics", "", "`Address` to serve Prometheus metrics on at /metrics, e.g. :9100, empty to disable")
+	flagGzipEncoding := flag.Bool("gzip-encoding", false, "Send .csv.gz files as is with Content-Encoding: gzip, the server must support it")
+	flagSymlinks := flag.String("symlinks", string(SymlinkFiles), "Symlinks `rule`: skip, or files - follow links to files, never to directories")
 
 	flag.Parse()
 	if flag.Parsed() {
@@ -178,11 +265,24 @@ func init() {
 		dirName = *flagDirName
 		concurrency = *flagConcurrency
 		verbose = *flagVerbose
-		timeout = time.Duration(*flagTimeout)
+		timeout = pollInterval.Duration
 		retryNumber = *flagRetryNumber
+		doneDir = *flagDoneDir
+		failedDir = *flagFailedDir
+		copyProcessed = *flagCopy
+		indexFileName = *flagIndexFile
+		forceUpload = *flagForce
+		maxDepth = *flagMaxDepth
+		if *flagNoRecurse {
+			maxDepth = 0
+		}
+		symlinkRule = SymlinkRule(*flagSymlinks)
+		gzipEncoding = *flagGzipEncoding
+		streamLabel = *flagLabel
+		authScheme = AuthScheme(*flagAuthScheme)
 
 		appName = os.Args[0]
-		if inFileName == "" && dirName == "" && len(os.Args) == 2 {
+		if inFileName == "" && dirName == "" && s3Location == "" && len(os.Args) == 2 {
 			inFileName = os.Args[1]
 		}
 	} else {
@@ -194,23 +294,29 @@ func init() {
 func usage() {
 	fmt.Printf("%s, ver. %s\n", appName, version)
 	fmt.Println("Command line:")
-	fmt.Printf("\tprompt$>%s -a <auth_key> -b <base_url> -t <request-type> [-f <filename> OR -d <dir>] -s <minutes> -v \n", appName)
+	fmt.Printf("\tprompt$>%s -a <auth_key> -b <base_url> -t <request-type> [-f <filename> OR -d <dir>] -s <interval> [-initial-delay <delay>] [-done <dir>] [-failed <dir>] [-copy] [-index <file>] [-force] [-include <patterns>] [-exclude <patterns>] [-depth <n>] -v \n", appName)
 	fmt.Println("Provide either file or dir. Dir takes over file, if both provided")
+	fmt.Println("Use -f - to read CSV from stdin, or -f <named pipe>")
+	fmt.Println("Use -s3 s3://bucket/prefix to take input files from S3-compatible storage instead")
+	fmt.Println("Compressed inputs are accepted: *.csv.gz, *.csv.bz2, and *.zip with each CSV entry as its own job")
 	flag.Usage()
 	os.Exit(-1)
 }
 
+// Flags holding credentials, not to be printed
+var secretFlags = map[string]bool{"a": true, "hmac-secret": true, "client-secret": true, "s3-secret-key": true}
+
+// Prints the value of every flag, the credentials redacted
 func printEnv() {
-	fmt.Printf("Provided: -a: %s, -b: %s, -r: %v, -f: %s, -d: %s, -c: %v, -s: %v, -v: %v \n",
-		authorizationKey,
-		baseUrl,
-		requestType,
-		inFileName,
-		dirName,
-		concurrency,
-		timeout,
-		verbose,
-	)
+	values := []string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "REDACTED"
+		}
+		values = append(values, fmt.Sprintf("-%s: %s", f.Name, value))
+	})
+	fmt.Printf("Provided: %s\n", strings.Join(values, ", "))
 }
 
 func ValidateRQType() bool {
@@ -218,7 +324,7 @@ func ValidateRQType() bool {
 		fmt.Println("Wrong request type parameter value provided: ", param_RQ_T)
 		fmt.Println("Valid values are:")
 		for _, rq_type := range dataType {
-			log.Println(rq_type.RQTypeParam)
+			fmt.Println(rq_type.RQTypeParam)
 		}
 		return false
 	}
@@ -250,16 +356,25 @@ const (
 )
 
 type JobType struct {
-	JobId    string
-	Filename string
+	JobId       string
+	Filename    string
+	ContentHash string
+	Input       InputFile
+	Posted      time.Time // when the server accepted the upload
+	Failure     string    // failure category, for failed jobs
+	RequestId   string    // correlation id of the accepted upload attempt, sent with its status checks
+	Span        *Span     // trace of the file, ended with the final status
+	Timings     StepTimings
+	Notes       string // server notes of the failed step
 }
 
 // Check status for a job
-func jobCompleted(job JobType) bool {
+func checkJobStatus(job JobType) StatusCheck {
 	// Call numerxData server to check the status of this job
-	// return true if we get:
+	// return CheckFinal if we get:
 	// 		[“step”=”metaindexstatus”, “status”=”success”]
 	//	or [“step”=“eventindexstatus”, “status” = “success”]
+	//	or a failed step, or the job is unknown to the server
 	/*
 		[
 			{"ID":"0.0.LqO~iOvJV3sdUOd8","Step":"metaindexstatus","Status":"success","Timestamp":1465589455508,"Notes":""},
@@ -270,23 +385,25 @@ func jobCompleted(job JobType) bool {
 	// uri string, resource string, params map[string]string
 	var params map[string]string = make(map[string]string)
 	params["id"] = job.JobId
+	jobLogger := jobLog(job)
 	request, err := fileUploadStatusRequest(baseUrl, "/status", params)
 	if err != nil {
-		log.Println(err)
-		return false // let the caller func to handle retries
+		jobLogger.Errorf("%v", err)
+		return CheckRetry // let the caller func to handle retries
 	}
 
-	if verbose {
-		log.Println("RQ URL: ", request.URL)
-		log.Println("RQ Headers: ", request.Header)
-		log.Println("RQ Body: ", request)
-	}
+	setRequestId(request, job.RequestId)
+	span := startRequestSpan("status", job.Span, request)
+	defer span.End()
+
+	jobLogger.Debugf("Status RQ URL: %v", request.URL)
+	jobLogger.Tracef("Status RQ Headers: %v", redactHeaders(request.Header))
 
-	client := &http.Client{}
-	resp, err := client.Do(request)
+	resp, err := sendRequest(StatusRequest, request)
 	if err != nil {
-		log.Println(err)
-		return false // let the caller func to handle retries
+		span.SetError(err.Error())
+		jobLogger.Warnf("Status check failed: %v", err)
+		return CheckRetry // let the caller func to handle retries
 	} else {
 		/* JSON
 		[
@@ -297,118 +414,134 @@ func jobCompleted(job JobType) bool {
 		*/
 		defer resp.Body.Close()
 
-		var bodyContent []byte
-		if verbose {
-			log.Println("Status RS Status: ", resp.StatusCode)
-			log.Println("Status RS Headers: ", resp.Header)
-		}
+		jobLogger.Debugf("Status RS Status: %d", resp.StatusCode)
+		span.SetAttribute("http.status_code", resp.StatusCode)
+		jobLogger.Tracef("Status RS Headers: %v", resp.Header)
 
 		bodyContent, err := ioutil.ReadAll(resp.Body)
-
-		if verbose {
-			log.Println("Status RS Content: error? :", err)
-			log.Println("Status RS Content: body: bytes:  ", bodyContent)
-			log.Println("Status RS Content: body: string: ", string(bodyContent))
+		if err != nil {
+			jobLogger.Warnf("Status RS Content: error reading the body: %v", err)
 		}
+		jobLogger.Tracef("Status RS Content: %s", truncateBody(bodyContent))
 		if resp.StatusCode == 200 {
 			// Check the step's status
 			status, err := getStatusResponse(bodyContent)
 			if err != nil {
-				log.Printf("Error %v while checking status for %v, file: %v \n", err, job.JobId, job.Filename)
-				return false // let the caller func to handle retries
+				jobLogger.Warnf("Error %v while checking status", err)
+				return CheckRetry // let the caller func to handle retries
 			} else {
-				switch requestType {
-				case RQ_Viewership:
-					for _, entry := range status {
-						switch entry.Step {
-						case string(IndexEventData): // "eventindexstatus":
-							switch entry.Status {
-							case string(Success): // "success":
-								if verbose {
-									log.Printf("Complete for: %s, file: %s\n", job.JobId, job.Filename)
-									log.Println("Current state: ", status)
-								}
-								return true
-							case string(Failure): // "failure":
-								failedJobsChan <- job
-								return true
-							}
-						case string(ParsedEventData), string(RawEventData):
-							if entry.Status == string(Failure) {
-								failedJobsChan <- job
-								return true
-							}
-						}
-					}
-
-					if verbose {
-						log.Printf("Not yet: %s, file: %s\n", job.JobId, job.Filename)
-						log.Println("Current state: ", status)
-					}
-
-				//	(actually the new struct with file-name, id, and retry-number)
-				case RQ_MetaBilling, RQ_MetaProgram, RQ_MetaChanMap, RQ_MetaEventMap:
-					for _, entry := range status {
-						switch entry.Step {
-						case string(IndexMetaData): // "metaindexstatus":
-							switch entry.Status {
-							case string(Success): // "success":
-								if verbose {
-									log.Printf("Complete for: %s, file: %s\n", job.JobId, job.Filename)
-									log.Println("Current state: ", status)
-								}
-								return true
-							case string(Failure): // "failure":
-								failedJobsChan <- job
-								return true
-							}
-						case string(ParsedMetaData), string(RawMetaData):
-							if entry.Status == string(Failure) {
-								failedJobsChan <- job
-								return true
-							}
-						}
-					}
-
-					if verbose {
-						log.Printf("Not yet: %s, file: %s\n", job.JobId, job.Filename)
-						log.Println("Current state: ", status)
-					}
-
+				observeSteps(job, status)
+				traceSteps(job.Span, status)
+				job.Timings = stepTimings(job, status)
+				state, latest := evaluateStatus(job, status)
+				if latest.Step != "" {
+					progress.SetStep(job.Filename, latest.Step, latest.Status)
+				}
+				switch state {
+				case JobIndexed:
+					jobLogger.Infof("Complete, %s", job.Timings)
+					jobLogger.Debugf("Current state: %v", status)
+					jobSucceeded(job)
+					return CheckFinal
+				case JobFailed:
+					stepFailed(job, latest)
+					return CheckFinal
 				}
+
+				jobLogger.Debugf("Not yet, current state: %v", status)
 			}
 		} else {
-			log.Println("Error Status %v while checking status for %v, file: %s \n", err, job.JobId, job.Filename)
-			failedJobsChan <- job
-			if verbose {
-				log.Println("Error Status %v while checking status for %v, file: %s \n", err, job.JobId, job.Filename)
+			span.SetError(resp.Status)
+			switch {
+			case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+				// Nothing will pass with these credentials
+				stopRun("Status check rejected with http %d, check the credentials and permissions", resp.StatusCode)
+			case resp.StatusCode == http.StatusNotFound:
+				jobLogger.Errorf("Job not found on the server")
+				jobFailed(job, FailureNotFound)
+				return CheckFinal
+			case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+				jobLogger.Warnf("Error Status %d while checking status, will retry", resp.StatusCode)
+				return CheckRetry
+			default:
+				jobLogger.Errorf("Error Status %d while checking status", resp.StatusCode)
+				jobFailed(job, FailureStatus)
+				return CheckFinal
 			}
-			return true
 		}
 	}
 
-	return false
+	return CheckPending
 }
 
-// General loop-function to wait for a job to complete on numerx side
-func waitingForJob(job JobType, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		// wait enough...
-		if verbose {
-			log.Println("Waiting for ", job.JobId)
-		}
-		time.Sleep(timeout * time.Minute)
-		// Check if the numerx server has completed this job yet
-		if jobCompleted(job) {
-			return
+// Records the indexed content and moves the file to the done directory
+func jobSucceeded(job JobType) {
+	if hashIndex != nil && job.ContentHash != "" {
+		err := hashIndex.Record(RQTypeParam(param_RQ_T), job.ContentHash, IndexEntry{
+			JobId:    job.JobId,
+			Filename: job.Filename,
+			Indexed:  time.Now(),
+		})
+		if err != nil {
+			jobLog(job).Warnf("Could not record the file in the index: %v", err)
 		}
 	}
+	disposeInput(job.Input, true)
+	filesIndexed.Inc(param_RQ_T)
+	recordTimings(job.Timings)
+	job.Span.End()
+	progress.SetPhase(job.Filename, PhaseIndexed)
+}
+
+// Reports the job failed on a server processing step, with its notes
+func stepFailed(job JobType, entry NumerXStatusResponse) {
+	job.Notes = entry.Notes
+	jobLog(job).Warnf("Step %s failed: %s", entry.Step, entry.Notes)
+	jobFailed(job, FailureProcessing)
+}
+
+// Stops the whole run on a configuration error, like rejected credentials;
+// the failed jobs so far are reported and the spans so far exported
+func stopRun(format string, args ...interface{}) {
+	stopRunOnce.Do(func() {
+		stopProgress()
+		logger.Errorf(format, args...)
+		removeSpoolFiles()
+		PrintFailedJobs(collectedFailedJobs())
+		if !quiet {
+			PrintTimingSummary()
+		}
+		shutdownTracing()
+		closeLogFile()
+		os.Exit(-1)
+	})
+	// Another check is stopping the run already
+	select {}
+}
+
+var stopRunOnce sync.Once
+
+// Reports the job failed with the failure category
+func jobFailed(job JobType, category string) {
+	job.Failure = category
+	failedJobsChan <- job
 }
 
 var jobsInProcessChann chan JobType
 var failedJobsChan chan JobType
 
+// Failed jobs collected by the failed jobs listener
+var failedJobs struct {
+	sync.Mutex
+	jobs []JobType
+}
+
+func collectedFailedJobs() []JobType {
+	failedJobs.Lock()
+	defer failedJobs.Unlock()
+	return append([]JobType(nil), failedJobs.jobs...)
+}
+
 func main() {
 
 	/*
@@ -438,13 +571,56 @@ func main() {
 	   -c concurrency
 	*/
 
-	if verbose {
+	parseFlags()
+
+	if err := initLogging(); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	if logEnabled(LevelDebug) {
 		printEnv()
 	}
 
-	if !ValidateRQType() {
+	if !ValidateRQType() || !validateSymlinkRule(string(symlinkRule)) || !validateProgressMode(progressMode) {
+		os.Exit(-1)
+	}
+
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		logger.Errorf("Could not set up TLS: %v", err)
+		os.Exit(-1)
+	}
+	if httpClient, err = newHTTPClient(tlsConfig); err != nil {
+		logger.Errorf("Could not set up the HTTP client: %v", err)
 		os.Exit(-1)
 	}
+	throttleUploads(httpClient)
+	measureUploads(httpClient)
+
+	if authenticator, err = newAuthenticator(tlsConfig); err != nil {
+		logger.Errorf("Could not set up authentication: %v", err)
+		os.Exit(-1)
+	}
+
+	initRateLimits()
+
+	if err = initTracing(); err != nil {
+		logger.Errorf("Could not set up tracing: %v", err)
+		os.Exit(-1)
+	}
+
+	if metricsEnabled() {
+		startMetricsServer()
+	}
+
+	if indexFileName != "" {
+		hashIndex, err = loadHashIndex(indexFileName)
+		if err != nil {
+			logger.Errorf("Could not load the index file: %v", err)
+			os.Exit(-1)
+		}
+	}
 
 	/*
 		// Get the list of CSV files
@@ -470,68 +646,136 @@ func main() {
 	jobsInProcessChann = make(chan JobType, concurrency)
 	failedJobsChan = make(chan JobType)
 
-	var failedJobs []JobType
-	failedJobs = make([]JobType, 0)
-
 	var wg sync.WaitGroup
 
+	// Closed by the failed jobs listener once it has drained failedJobsChan
+	failedJobsDone := make(chan bool)
+
 	// Start listening for failed jobs
 	go func() {
-		if verbose {
-			log.Println("Ready to start logging failed jobs...")
-		}
+		defer close(failedJobsDone)
+		logger.Debugf("Ready to start logging failed jobs...")
 		for {
 			nextFailedJob, more := <-failedJobsChan
 			if more {
-				if verbose {
-					log.Println("Got failed job: ", nextFailedJob)
-					failedJobs = append(failedJobs, nextFailedJob)
-					wg.Done()
-				}
+				jobLog(nextFailedJob).Debugf("Got failed job, %s", nextFailedJob.Failure)
+				failedJobs.Lock()
+				failedJobs.jobs = append(failedJobs.jobs, nextFailedJob)
+				failedJobs.Unlock()
+				filesFailed.Inc(param_RQ_T, nextFailedJob.Failure)
+				recordTimings(nextFailedJob.Timings)
+				nextFailedJob.Span.SetError(nextFailedJob.Failure)
+				nextFailedJob.Span.End()
+				progress.SetPhase(nextFailedJob.Filename, PhaseFailed)
+				disposeInput(nextFailedJob.Input, false)
 			} else {
-				if verbose {
-					log.Println("Got all Failed Jobs, breaking")
-				}
+				logger.Debugf("Got all Failed Jobs, breaking")
 				return
 			}
 		}
 	}()
 
+	// One scheduler checks the status of all submitted jobs
+	poller := NewStatusPoller(pollConcurrency, &wg)
+	go poller.Run()
+
 	// Start listening for the job Ids
 	go func() {
-		if verbose {
-			log.Println("Ready to start getting Ids to wait for completeion...")
-		}
+		logger.Debugf("Ready to start getting Ids to wait for completion...")
 		for {
 			nextJob, more := <-jobsInProcessChann
 			if more {
-				if verbose {
-					log.Println("Starting waiting for: ", nextJob.JobId)
-				}
-				go waitingForJob(nextJob, &wg)
+				jobLog(nextJob).Debugf("Starting waiting for the job")
+				poller.Add(nextJob)
 			} else {
-				if verbose {
-					log.Println("Got all Ids, breaking")
-				}
+				logger.Debugf("Got all Ids, breaking")
 				return
 			}
 		}
 	}()
 
 	files := getFilesToProcess()
+	filesDiscovered.Add(float64(len(files)), param_RQ_T)
+
+	trackArchives(files)
+	startProgress(files)
 
-	for _, eachFile := range files {
+	// Limits the bytes being uploaded at once, next to the sem limit for the number of files
+	budget := NewByteBudget(int64(inFlightBytes))
+
+	for _, eachInput := range files {
 		// if we still have available goroutine in the pool (out of concurrency )
 		sem <- true
 
 		// fire one file to be processed in a goroutine
 		wg.Add(1)
 
-		log.Println("About to process: ", eachFile)
-		go func(fileName string) {
+		fileLog(eachInput.Name).Infof("About to process")
+		go func(input InputFile) {
+			fileName := input.Name
+			fileLogger := fileLog(fileName)
+
 			// Signal end of processing at the end
 			defer func() { <-sem }()
 
+			// Waits for the file to fit into the in-flight bytes budget here, not in the loop,
+			// so that the smaller files behind a waiting big one go ahead of it
+			uploadSize := input.UploadSize()
+			budget.Acquire(uploadSize)
+			defer budget.Release(uploadSize)
+
+			// Once the job Id is handed over to the status waiter, it signals the end of processing instead
+			handedOver := false
+			defer func() {
+				if !handedOver {
+					wg.Done()
+				}
+			}()
+
+			progress.SetPhase(fileName, PhaseUploading)
+
+			// One trace per file, local validation first
+			span := startSpan("file", nil)
+			span.SetAttribute("file", fileName)
+			span.SetAttribute("data_type", param_RQ_T)
+			span.SetAttribute("size", uploadSize)
+			validation := startSpan("validate", span)
+
+			// S3 objects are downloaded once, if they are read more than once
+			input, err := spoolS3Input(input)
+			var contentHash string
+			if err == nil && hashIndex != nil {
+				contentHash, err = inputHash(input)
+			}
+			if err != nil {
+				fileLogger.Errorf("%v", err)
+				validation.SetError(err.Error())
+				validation.End()
+				failedJobsChan <- JobType{
+					JobId:    err.Error(),
+					Filename: fileName,
+					Input:    input,
+					Failure:  FailureRead,
+					Span:     span,
+				}
+				return
+			}
+
+			if hashIndex != nil {
+				if entry, ok := hashIndex.Lookup(RQTypeParam(param_RQ_T), contentHash); ok && !forceUpload {
+					fileLogger.Infof("Skipping, same content already indexed as %s (job %s)", entry.Filename, entry.JobId)
+					disposeInput(input, true)
+					validation.End()
+					span.SetAttribute("skipped", "already indexed as "+entry.JobId)
+					progress.SetPhase(fileName, PhaseSkipped)
+					span.End()
+					return
+				}
+			}
+
+			uploadsInFlight.Inc(param_RQ_T)
+			defer uploadsInFlight.Dec(param_RQ_T)
+
 			var extraParams map[string]string = make(map[string]string)
 
 			switch requestType {
@@ -545,36 +789,65 @@ func main() {
 				extraParams["csvHeaderLine"] = "1"
 			}
 
-			request, err := newfileUploadRequest(baseUrl, string(requestType), extraParams, eachFile)
+			request, err := newfileUploadRequest(baseUrl, string(requestType), extraParams, input)
 			if err != nil {
 				// Wrong parameters/request - do not try again
-				log.Println(err)
+				fileLogger.Errorf("%v", err)
+				validation.SetError(err.Error())
+				validation.End()
 				failedJobsChan <- JobType{
 					JobId:    err.Error(),
-					Filename: eachFile,
+					Filename: fileName,
+					Input:    input,
+					Failure:  FailureRequest,
+					Span:     span,
 				}
 				return
 			}
 
-			if verbose {
-				log.Println("POST RQ URL: ", request.URL)
-				log.Println("POST RQ Headers: ", request.Header)
-				log.Println("POST RQ Body: ", request)
-			}
+			validation.End()
 
-			client := &http.Client{}
+			fileLogger.Debugf("POST RQ URL: %v", request.URL)
 
 			postRequestSucceeded := false
 			var resp *http.Response
 			retryNo := retryNumber // retryNo for http 500 Server errors re-tries
+			requestSent := false
+			attempt := 0 // all attempts, for the logs
+			requestId := ""
 		RETRY_LABEL:
 			for attemptNumber := 0; attemptNumber < retryNumber; attemptNumber++ {
-				resp, err = client.Do(request)
-				if err != nil { // timeout re-tries are handled here
-					if verbose {
-						log.Printf("Attempt # %d for %s failed.\n", attemptNumber+1, eachFile)
+				if requestSent {
+					// the body has been consumed by the previous attempt, open the input again
+					if request.Body, err = request.GetBody(); err != nil {
+						break
+					}
+					if err = authenticator.Authenticate(request); err != nil {
+						break
 					}
-					time.Sleep(timeout)
+				}
+				requestSent = true
+				attempt++
+				requestId = newRequestId()
+				setRequestId(request, requestId)
+				fileLogger = fileLog(fileName).Attempt(attempt).Request(requestId)
+				postSpan := startRequestSpan("POST", span, request)
+				postSpan.SetAttribute("attempt", attempt)
+				postSpan.SetAttribute("request_id", requestId)
+				fileLogger.Tracef("POST RQ Headers: %v", redactHeaders(request.Header))
+				resp, err = sendRequest(UploadRequest, request)
+				if err != nil {
+					postSpan.SetError(err.Error())
+				} else {
+					postSpan.SetAttribute("http.status_code", resp.StatusCode)
+					if resp.StatusCode != 200 {
+						postSpan.SetError(resp.Status)
+					}
+				}
+				postSpan.End()
+				if err != nil { // timeout re-tries are handled here
+					fileLogger.Warnf("Attempt # %d failed: %v", attemptNumber+1, err)
+					time.Sleep(retryWait)
 				} else {
 					postRequestSucceeded = true
 					break
@@ -582,10 +855,14 @@ func main() {
 			}
 
 			if !postRequestSucceeded {
-				log.Println(err)
+				fileLogger.Errorf("%v", err)
 				failedJobsChan <- JobType{
-					JobId:    time.Now().String() + ":" + err.Error(),
-					Filename: eachFile,
+					JobId:     time.Now().String() + ":" + err.Error(),
+					Filename:  fileName,
+					Input:     input,
+					Failure:   FailurePost,
+					RequestId: requestId,
+					Span:      span,
 				}
 				return
 			} else {
@@ -593,32 +870,42 @@ func main() {
 				// JSON {"id" : "0.0.LqO~iOvJV3sdUOd8"}
 				defer resp.Body.Close()
 
-				if verbose {
-					log.Println("POST Status code: ", resp.StatusCode)
-					log.Println("POST Headers: ", resp.Header)
-				}
+				fileLogger.Debugf("POST Status code: %d", resp.StatusCode)
+				fileLogger.Tracef("POST Headers: %v", resp.Header)
 				bodyContent, err := ioutil.ReadAll(resp.Body)
-
-				if verbose {
-					log.Printf("POST - File:[%s] Response body: %s\n", eachFile, string(bodyContent))
-					log.Println("POST - Status RS Content: error? :", err)
-					log.Println("POST - Status RS Content: body: ", bodyContent)
+				if err != nil {
+					fileLogger.Warnf("POST response: error reading the body: %v", err)
 				}
+				fileLogger.Tracef("POST Response body: %s", truncateBody(bodyContent))
 				if resp.StatusCode == 200 {
 					// get the id of the job on numerX server
 					// sent this Id to the StatusChecker channel
 					jobId, err := GetJobId(bodyContent)
 					if err != nil {
-						log.Printf("Error [%v] for submitting %v \n", err, eachFile)
-					} else {
-						if verbose {
-							log.Printf("Posted file [%s] with Id {%s}, about to start checking on status update\n", eachFile, jobId)
+						fileLogger.Errorf("Error [%v] for submitting", err)
+						failedJobsChan <- JobType{
+							JobId:     err.Error(),
+							Filename:  fileName,
+							Input:     input,
+							Failure:   FailureResponse,
+							RequestId: requestId,
+							Span:      span,
 						}
+					} else {
+						fileLogger.Job(jobId).Infof("Posted, about to start checking on status update")
 
 						newJob := JobType{
-							JobId:    jobId,
-							Filename: eachFile,
+							JobId:       jobId,
+							Filename:    fileName,
+							Input:       input,
+							ContentHash: contentHash,
+							Posted:      time.Now(),
+							RequestId:   requestId,
+							Span:        span,
 						}
+						filesPosted.Inc(param_RQ_T)
+						handedOver = true
+						progress.SetPhase(fileName, PhaseProcessing)
 						jobsInProcessChann <- newJob
 					}
 				} else if resp.StatusCode == 500 {
@@ -626,70 +913,80 @@ func main() {
 					retryNo--
 					if retryNo <= 0 {
 						failedJobsChan <- JobType{
-							JobId:    "http " + strconv.Itoa(resp.StatusCode),
-							Filename: eachFile,
+							JobId:     "http " + strconv.Itoa(resp.StatusCode),
+							Filename:  fileName,
+							Input:     input,
+							Failure:   FailureHttp,
+							RequestId: requestId,
+							Span:      span,
 						}
 						return
 					} else {
 						resp.Body.Close()
-						if verbose {
-							log.Printf("Attempt # %d for %s failed.\n", retryNumber-retryNo, eachFile)
-						}
-						time.Sleep(timeout)
+						fileLogger.Warnf("Attempt # %d failed with http %d", retryNumber-retryNo, resp.StatusCode)
+						time.Sleep(retryWait)
 						goto RETRY_LABEL
 					}
 
 				} else { // all other HTTP response codes
-					log.Println("Error Status [%v] for submitting %v \n", err, string(bodyContent))
-					if verbose {
-						log.Println("Error Status [%v] for submitting %v \n", err, string(bodyContent))
-					}
+					fileLogger.Errorf("Error Status [%d] for submitting: %s", resp.StatusCode, truncateBody(bodyContent))
 					failedJobsChan <- JobType{
-						JobId:    "",
-						Filename: eachFile,
+						JobId:     "",
+						Filename:  fileName,
+						Input:     input,
+						Failure:   FailureHttp,
+						RequestId: requestId,
+						Span:      span,
 					}
 					return
 				}
 			}
 
-		}(eachFile)
+		}(eachInput)
 	}
 
 	// waiting for all goroutines to end
-	if verbose {
-		log.Println("Waiting for all goroutines to complete the work")
-	}
+	logger.Debugf("Waiting for all goroutines to complete the work")
 
 	for i := 0; i < cap(sem); i++ {
 		sem <- true
 	}
 
 	// Now waiting for status-waiter processes to end
-	log.Println("Waiting for all status checks to complete")
+	logger.Infof("Waiting for all status checks to complete")
 	wg.Wait()
 
 	// Done all gouroutines, close the jobs listener channel
-	log.Println("Initial POST files complete, closing jobs processing channel")
+	logger.Debugf("Initial POST files complete, closing jobs processing channel")
 	close(jobsInProcessChann)
+	poller.Stop()
 
 	// Done all gouroutines, close the failed jobs listener channel
-	log.Println("Failed jobs processing complete, closing processing channel")
+	logger.Debugf("Failed jobs processing complete, closing processing channel")
 	close(failedJobsChan)
+	<-failedJobsDone
 
-	log.Println("jobs channel closed")
+	logger.Debugf("jobs channel closed")
 
-	log.Printf("Processed %d files, in %v\n", len(files), time.Since(startTime))
+	stopProgress()
+	logger.Infof("Processed %d files, in %v", len(files), time.Since(startTime))
+	removeSpoolFiles()
 
-	if len(failedJobs) > 0 {
-		PrintFailedJobs(failedJobs)
+	if failed := collectedFailedJobs(); len(failed) > 0 {
+		PrintFailedJobs(failed)
 	} else {
-		log.Println("No failed jobs reported")
+		logger.Infof("No failed jobs reported")
 	}
+	if !quiet {
+		PrintTimingSummary()
+	}
+	shutdownTracing()
+	closeLogFile()
 }
 
 func PrintFailedJobs(failedJobs []JobType) {
 	for _, job := range failedJobs {
-		log.Printf("Failed job: [%s], file: %s\n", job.JobId, job.Filename)
+		jobLog(job).Errorf("Failed job: [%s], %s, request id: %s, timings: %s, notes: %s", job.JobId, job.Failure, job.RequestId, job.Timings, job.Notes)
 	}
 }
 
@@ -697,75 +994,78 @@ type NumerXPOSTResponse struct {
 	Id string `json:"id"`
 }
 
-type NumerXStatusResponse struct {
-	ID        string `json:"id"`
-	Step      string `json:"step"`
-	Status    string `json:"status"`
-	Timestamp int    `json:"timestamp"`
-	Notes     string `json:"notes"`
-}
-
-// Unmarshal Status response to []NumerXStatusResponse
-func getStatusResponse(bodyContent []byte) ([]NumerXStatusResponse, error) {
-	var response []NumerXStatusResponse
-	if verbose {
-		log.Println("Status response: Bytes:  ", bodyContent)
-		log.Println("Status response: String: ", string(bodyContent))
-	}
-	err := json.Unmarshal(bodyContent, &response)
-	if err != nil {
-		return nil, err
-	}
-	return response, nil
-}
-
 // Unmarshall POST response to job Id
 func GetJobId(bodyContent []byte) (string, error) {
 	var response NumerXPOSTResponse
-	if verbose {
-		log.Println("Post response: Bytes:  ", bodyContent)
-		log.Println("Post response: String: ", string(bodyContent))
-	}
 	err := json.Unmarshal(bodyContent, &response)
 	if err != nil {
 		return "", err
 	}
-
-	if verbose {
-		log.Println("Post response: Id: ", response.Id)
-	}
 	return response.Id, nil
 }
 
 // Get the list of files to process in the target folder
-func getFilesToProcess() []string {
-	fileList := []string{}
+func getFilesToProcess() []InputFile {
+	fileList := []InputFile{}
 	singleFileMode = false
 
+	if s3Location != "" {
+		inputs, err := s3InputFiles(s3Location)
+		if err != nil {
+			logger.Errorf("Error getting S3 objects list: %v", err)
+			os.Exit(-1)
+		}
+		return inputs
+	}
+
 	if dirName == "" {
 		if inFileName != "" {
 			// no Dir name provided, but file name provided =>
 			// Single file mode
 			singleFileMode = true
-			fileList = append(fileList, inFileName)
-			return fileList
+			if isStreamInput(inFileName) {
+				input, err := streamInput(inFileName, streamLabel)
+				if err != nil {
+					logger.Errorf("Error reading input stream: %v", err)
+					removeSpoolFiles()
+					os.Exit(-1)
+				}
+				return []InputFile{input}
+			}
+			inputs, err := expandInput(inFileName)
+			if err != nil {
+				fileLog(inFileName).Errorf("Error reading input file: %v", err)
+				os.Exit(-1)
+			}
+			return inputs
 		} else {
 			// no Dir name, no file name
-			log.Println("Input file name or working directory is not provided")
+			logger.Errorf("Input file name or working directory is not provided")
 			usage()
 		}
 	}
 
 	// We have working directory - takes over single file name, if both provided
-	err := filepath.Walk(dirName, func(path string, f os.FileInfo, _ error) error {
-		if isCsvFile(path) {
-			fileList = append(fileList, path)
+	err := filepath.Walk(dirName, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			fileLog(path).Debugf("Skipping: %v", err)
+			return nil
+		}
+		take, err := scanFilter(path, f)
+		if take {
+			inputs, expandErr := expandInput(path)
+			if expandErr != nil {
+				fileLog(path).Warnf("Skipping: %v", expandErr)
+			} else if len(inputs) == 0 {
+				fileLog(path).Warnf("Skipping: no CSV entries")
+			}
+			fileList = append(fileList, inputs...)
 		}
-		return nil
+		return err
 	})
 
 	if err != nil {
-		log.Println("Error getting files list: ", err)
+		logger.Errorf("Error getting files list: %v", err)
 		os.Exit(-1)
 	}
 
@@ -773,5 +1073,5 @@ func getFilesToProcess() []string {
 }
 
 func isCsvFile(fileName string) bool {
-	return filepath.Ext(fileName) == "."+csvExt
+	return strings.EqualFold(filepath.Ext(fileName), "."+csvExt)
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthOAuth2 AuthScheme = "oauth2"
+
+	// Tokens are refreshed this long before they expire, or at 90% of a shorter lifetime
+	tokenRefreshMargin = time.Minute
+)
+
+var (
+	tokenUrl     string
+	clientId     string
+	clientSecret string
+	tokenScope   string
+	tokenAuthIn  string
+)
+
+// Authenticators with expiring credentials, which can be dropped after a 401 and fetched again
+type RefreshableAuthenticator interface {
+	Authenticator
+	Invalidate(request *http.Request)
+}
+
+// OAuth2 client credentials grant: the bearer token is cached and refreshed before it expires
+type OAuth2Auth struct {
+	sync.Mutex
+	TokenUrl     string
+	ClientId     string
+	ClientSecret string
+	Scope        string
+	// basic - client credentials in the Authorization header, body - in the form
+	ClientAuthIn string
+
+	client    *http.Client
+	token     string
+	refreshAt time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (auth *OAuth2Auth) Authenticate(request *http.Request) error {
+	token, err := auth.currentToken()
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Drops the cached token, unless it has already been replaced since the request was authenticated
+func (auth *OAuth2Auth) Invalidate(request *http.Request) {
+	auth.Lock()
+	defer auth.Unlock()
+
+	if request.Header.Get("Authorization") == "Bearer "+auth.token {
+		auth.token = ""
+	}
+}
+
+func (auth *OAuth2Auth) currentToken() (string, error) {
+	auth.Lock()
+	defer auth.Unlock()
+
+	if auth.token != "" && time.Now().Before(auth.refreshAt) {
+		return auth.token, nil
+	}
+
+	token, lifetime, err := auth.fetchToken()
+	if err != nil {
+		return "", err
+	}
+
+	margin := tokenRefreshMargin
+	if lifetime > 0 && lifetime/10 < margin {
+		margin = lifetime / 10
+	}
+	auth.token = token
+	auth.refreshAt = time.Now().Add(lifetime - margin)
+	if lifetime <= 0 {
+		// No expires_in: keep the token until the server rejects it
+		auth.refreshAt = time.Now().Add(100 * 365 * 24 * time.Hour)
+	}
+
+	logger.Debugf("Got OAuth2 token, expires in %v", lifetime)
+	return auth.token, nil
+}
+
+func (auth *OAuth2Auth) fetchToken() (string, time.Duration, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if auth.Scope != "" {
+		form.Set("scope", auth.Scope)
+	}
+	if auth.ClientAuthIn == "body" {
+		form.Set("client_id", auth.ClientId)
+		form.Set("client_secret", auth.ClientSecret)
+	}
+
+	request, err := http.NewRequest("POST", auth.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if auth.ClientAuthIn != "body" {
+		request.SetBasicAuth(url.QueryEscape(auth.ClientId), url.QueryEscape(auth.ClientSecret))
+	}
+
+	resp, err := auth.client.Do(request)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token request failed: http %d: %s", resp.StatusCode, string(content))
+	}
+
+	var token tokenResponse
+	if err = json.Unmarshal(content, &token); err != nil {
+		return "", 0, err
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("no access_token in the token response")
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+func newOAuth2Auth(client *http.Client) (*OAuth2Auth, error) {
+	if tokenUrl == "" || clientId == "" || clientSecret == "" {
+		return nil, errors.New("oauth2 auth needs -token-url, -client-id and -client-secret")
+	}
+	if tokenAuthIn != "basic" && tokenAuthIn != "body" {
+		return nil, fmt.Errorf("unknown -token-auth %q, valid values are basic, body", tokenAuthIn)
+	}
+	return &OAuth2Auth{
+		TokenUrl:     tokenUrl,
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		Scope:        tokenScope,
+		ClientAuthIn: tokenAuthIn,
+		client:       client,
+	}, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	statusRetries   int
+	pollConcurrency int
+	pollMaxInterval time.Duration
+	pollGrowth      float64
+	initialDelay    durationValue
+)
+
+// Flag value in Go duration syntax (30s, 2m, 1m30s); a plain number means minutes, as -s used to take
+type durationValue struct {
+	time.Duration
+	set bool
+}
+
+func (d *durationValue) String() string {
+	if d == nil || d.Duration == 0 {
+		return ""
+	}
+	return d.Duration.String()
+}
+
+// Zero or negative values are rejected, the status checks would not wait between each other
+func (d *durationValue) Set(value string) error {
+	var duration time.Duration
+	if minutes, err := strconv.Atoi(value); err == nil {
+		duration = time.Duration(minutes) * time.Minute
+	} else if duration, err = time.ParseDuration(value); err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return errors.New("must be more than 0")
+	}
+	d.Duration = duration
+	d.set = true
+	return nil
+}
+
+// Result of a status check
+type StatusCheck int
+
+const (
+	CheckPending StatusCheck = iota // no final status yet
+	CheckFinal                      // the job has its final status
+	CheckRetry                      // the check failed on an error that may pass, like a 5xx or a timeout
+)
+
+// A job waiting for its next status check
+type pollEntry struct {
+	job      JobType
+	next     time.Time
+	interval time.Duration
+	errors   int // status check errors in a row
+	index    int
+}
+
+// Min-heap of entries by the next check time
+type pollQueue []*pollEntry
+
+func (q pollQueue) Len() int           { return len(q) }
+func (q pollQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q pollQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *pollQueue) Push(x interface{}) {
+	entry := x.(*pollEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *pollQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// Single scheduler for the status checks of all submitted jobs.
+// Checks run with bounded concurrency; the first check is after -initial-delay, the interval
+// between the next checks of a job starts at -s and grows by -poll-growth up to -poll-max as the job ages
+type StatusPoller struct {
+	sync.Mutex
+	queue pollQueue
+	wake  chan bool
+	quit  chan bool
+	sem   chan bool
+	wg    *sync.WaitGroup
+}
+
+// wg is signalled once per job, when its final status is known
+func NewStatusPoller(concurrency int, wg *sync.WaitGroup) *StatusPoller {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &StatusPoller{
+		wake: make(chan bool, 1),
+		quit: make(chan bool),
+		sem:  make(chan bool, concurrency),
+		wg:   wg,
+	}
+}
+
+// Schedules the first status check of the job
+func (p *StatusPoller) Add(job JobType) {
+	delay := timeout
+	if initialDelay.set {
+		delay = initialDelay.Duration
+	}
+	jobsPolling.Inc(param_RQ_T)
+	p.schedule(&pollEntry{
+		job:      job,
+		next:     time.Now().Add(delay),
+		interval: timeout,
+	})
+}
+
+func (p *StatusPoller) schedule(entry *pollEntry) {
+	p.Lock()
+	heap.Push(&p.queue, entry)
+	p.Unlock()
+
+	// Wake up the loop, in case the new entry is due earlier than the one it sleeps for
+	select {
+	case p.wake <- true:
+	default:
+	}
+}
+
+func (p *StatusPoller) Stop() {
+	close(p.quit)
+}
+
+// Dispatches the due checks until stopped
+func (p *StatusPoller) Run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		p.Lock()
+		var due []*pollEntry
+		now := time.Now()
+		for p.queue.Len() > 0 && !p.queue[0].next.After(now) {
+			due = append(due, heap.Pop(&p.queue).(*pollEntry))
+		}
+		wait := time.Hour
+		if p.queue.Len() > 0 {
+			wait = p.queue[0].next.Sub(now)
+		}
+		p.Unlock()
+
+		for _, entry := range due {
+			select {
+			case p.sem <- true:
+			case <-p.quit:
+				return
+			}
+			go p.check(entry)
+		}
+		if len(due) > 0 {
+			// Time has passed while waiting for the check slots
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-p.wake:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *StatusPoller) check(entry *pollEntry) {
+	defer func() { <-p.sem }()
+
+	jobLog(entry.job).Debugf("Checking status")
+	switch checkJobStatus(entry.job) {
+	case CheckFinal:
+		p.done(entry)
+		return
+	case CheckRetry:
+		entry.errors++
+		if entry.errors > statusRetries {
+			jobLog(entry.job).Errorf("Giving up on the status checks after %d errors in a row", entry.errors)
+			jobFailed(entry.job, FailureStatus)
+			p.done(entry)
+			return
+		}
+	default:
+		entry.errors = 0
+	}
+
+	entry.next = time.Now().Add(entry.interval)
+	jobLog(entry.job).Debugf("Next check in %v", entry.interval)
+
+	if pollGrowth > 1 {
+		entry.interval = time.Duration(float64(entry.interval) * pollGrowth)
+	}
+	if pollMaxInterval > 0 && entry.interval > pollMaxInterval {
+		entry.interval = pollMaxInterval
+	}
+	p.schedule(entry)
+}
+
+// The job has its final status
+func (p *StatusPoller) done(entry *pollEntry) {
+	jobsPolling.Dec(param_RQ_T)
+	forgetSteps(entry.job)
+	p.wg.Done()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const collisionTimeFormat = "20060102-150405.000"
+
+// Zip archives are moved once all their entries have their final status
+type archiveStatus struct {
+	pending int
+	failed  bool
+}
+
+var (
+	archivesLock sync.Mutex
+	archives     = make(map[string]*archiveStatus)
+)
+
+// Registers the entries of zip archives before processing
+func trackArchives(inputs []InputFile) {
+	archivesLock.Lock()
+	defer archivesLock.Unlock()
+
+	for _, input := range inputs {
+		if input.Entry == "" {
+			continue
+		}
+		if archives[input.Path] == nil {
+			archives[input.Path] = &archiveStatus{}
+		}
+		archives[input.Path].pending++
+	}
+}
+
+// Moves the input's file into the done or failed directory;
+// an archive goes to failed if any of its entries failed
+func disposeInput(input InputFile, succeeded bool) {
+	if input.Stream {
+		return
+	}
+	if input.S3Key != "" {
+		if input.Path != "" {
+			os.Remove(input.Path)
+		}
+		disposeS3Object(input.S3Key, succeeded)
+		return
+	}
+	if input.Entry == "" {
+		disposeFile(input.Path, succeeded)
+		return
+	}
+
+	archivesLock.Lock()
+	status := archives[input.Path]
+	if status == nil {
+		archivesLock.Unlock()
+		return
+	}
+	status.pending--
+	status.failed = status.failed || !succeeded
+	done, failed := status.pending == 0, status.failed
+	archivesLock.Unlock()
+
+	if done {
+		disposeFile(input.Path, !failed)
+	}
+}
+
+// Moves (or copies, if -copy is set) a file with known final status
+// into the done or failed directory, preserving its path relative to the working directory
+func disposeFile(fileName string, succeeded bool) {
+	targetDir := failedDir
+	if succeeded {
+		targetDir = doneDir
+	}
+	if targetDir == "" {
+		return
+	}
+
+	target := filepath.Join(targetDir, relativeName(fileName))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		fileLog(fileName).Errorf("Could not create directory for %s: %v", target, err)
+		return
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		target = timestampedName(target, time.Now())
+	}
+
+	var err error
+	if copyProcessed {
+		err = copyFile(fileName, target)
+	} else {
+		err = moveFile(fileName, target)
+	}
+	if err != nil {
+		fileLog(fileName).Errorf("Could not put the file into %s: %v", targetDir, err)
+		return
+	}
+
+	fileLog(fileName).Debugf("File -> %s", target)
+}
+
+// Path of the file relative to the filepath.Walk root, or just its base name in single file mode
+func relativeName(fileName string) string {
+	if dirName != "" {
+		if rel, err := filepath.Rel(dirName, fileName); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return filepath.Base(fileName)
+}
+
+// file.csv -> file_20160612-101530.000.csv, file.csv.gz -> file_20160612-101530.000.csv.gz
+func timestampedName(fileName string, t time.Time) string {
+	ext := filepath.Ext(fileName)
+	if compressionOf(fileName) != NoCompression && compressionOf(fileName) != Zip {
+		ext = filepath.Ext(strings.TrimSuffix(fileName, ext)) + ext
+	}
+	return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(fileName, ext), t.Format(collisionTimeFormat), ext)
+}
+
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	// Rename does not work across devices, fall back to copy and remove
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// True if the path is one of the done/failed directories, so that filepath.Walk skips them
+func isDispositionDir(path string) bool {
+	for _, dir := range []string{doneDir, failedDir} {
+		if dir == "" {
+			continue
+		}
+		if a, err := filepath.Abs(dir); err == nil {
+			if b, err := filepath.Abs(path); err == nil && a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
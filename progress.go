@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ProgressMode string
+
+const (
+	ProgressAuto ProgressMode = "auto"
+	ProgressOn   ProgressMode = "on"
+	ProgressOff  ProgressMode = "off"
+)
+
+// File phases in the progress view
+const (
+	PhaseQueued     = "queued"
+	PhaseUploading  = "uploading"
+	PhaseProcessing = "processing"
+	PhaseIndexed    = "indexed"
+	PhaseSkipped    = "skipped"
+	PhaseFailed     = "failed"
+)
+
+var (
+	progressMode string
+
+	progressRefresh = 500 * time.Millisecond
+	progressFiles   = 10 // files listed with their current step
+)
+
+func validateProgressMode(mode string) bool {
+	switch ProgressMode(mode) {
+	case ProgressAuto, ProgressOn, ProgressOff:
+		return true
+	}
+	fmt.Printf("Wrong progress value provided: %s\nValid values are: %s, %s, %s\n", mode, ProgressAuto, ProgressOn, ProgressOff)
+	return false
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type fileProgress struct {
+	phase   string
+	step    string
+	status  string
+	updated time.Time
+}
+
+// Live view of the run on stdout, redrawn in place; log lines are written above it
+type Progress struct {
+	sync.Mutex
+	out     io.Writer
+	start   time.Time
+	files   map[string]*fileProgress
+	total   int
+	bytes   int64
+	size    int64
+	lines   int // lines of the last drawing, to clear
+	stop    chan bool
+	stopped chan bool
+}
+
+// nil if the view is off
+var progress *Progress
+
+func progressEnabled() bool {
+	switch ProgressMode(progressMode) {
+	case ProgressOn:
+		return true
+	case ProgressAuto:
+		return isTerminal(os.Stdout)
+	}
+	return false
+}
+
+// Starts the view for the files, log lines go above it from now on
+func startProgress(files []InputFile) {
+	if !progressEnabled() {
+		return
+	}
+	p := &Progress{
+		out:     os.Stdout,
+		start:   time.Now(),
+		files:   make(map[string]*fileProgress),
+		total:   len(files),
+		stop:    make(chan bool),
+		stopped: make(chan bool),
+	}
+	for _, input := range files {
+		p.files[input.Name] = &fileProgress{phase: PhaseQueued, updated: p.start}
+		if size := input.UploadSize(); size > 0 {
+			p.size += size
+		}
+	}
+	log.SetOutput(progressLogWriter{p, log.Writer()})
+	progress = p
+	go p.run()
+}
+
+func (p *Progress) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(progressRefresh)
+	defer ticker.Stop()
+
+	for {
+		p.Lock()
+		p.clear()
+		p.draw()
+		p.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Draws the final state and leaves it on the screen
+func stopProgress() {
+	if progress == nil {
+		return
+	}
+	close(progress.stop)
+	<-progress.stopped
+
+	progress.Lock()
+	progress.clear()
+	progress.draw()
+	progress.lines = 0
+	if writer, ok := log.Writer().(progressLogWriter); ok {
+		log.SetOutput(writer.out)
+	}
+	progress.Unlock()
+}
+
+func (p *Progress) SetPhase(fileName string, phase string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if file := p.files[fileName]; file != nil {
+		file.phase = phase
+		file.updated = time.Now()
+	}
+}
+
+// The latest server step of the file
+func (p *Progress) SetStep(fileName string, step string, status string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if file := p.files[fileName]; file != nil && (file.step != step || file.status != status) {
+		file.step = step
+		file.status = status
+		file.updated = time.Now()
+	}
+}
+
+func (p *Progress) AddBytes(n int64) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	p.bytes += n
+	p.Unlock()
+}
+
+func (p *Progress) clear() {
+	for i := 0; i < p.lines; i++ {
+		fmt.Fprint(p.out, "\033[1A\033[2K")
+	}
+	p.lines = 0
+}
+
+func (p *Progress) draw() {
+	counts := map[string]int{}
+	active := []string{}
+	for name, file := range p.files {
+		counts[file.phase]++
+		if file.phase == PhaseUploading || file.phase == PhaseProcessing {
+			active = append(active, name)
+		}
+	}
+	done := counts[PhaseIndexed] + counts[PhaseSkipped] + counts[PhaseFailed]
+	elapsed := time.Since(p.start)
+
+	lines := []string{
+		fmt.Sprintf("Files: %d/%d done | queued %d, uploading %d, processing %d, indexed %d, skipped %d, failed %d",
+			done, p.total, counts[PhaseQueued], counts[PhaseUploading], counts[PhaseProcessing],
+			counts[PhaseIndexed], counts[PhaseSkipped], counts[PhaseFailed]),
+		fmt.Sprintf("Uploaded: %s of %s | elapsed %v | ETA %s",
+			formatBytes(p.bytes), formatBytes(p.size), elapsed.Round(time.Second), p.eta(done, elapsed)),
+	}
+
+	// The longest waiting files first
+	sort.Slice(active, func(i, j int) bool {
+		return p.files[active[i]].updated.Before(p.files[active[j]].updated)
+	})
+	for i, name := range active {
+		if i == progressFiles {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(active)-progressFiles))
+			break
+		}
+		file := p.files[name]
+		step := file.phase
+		if file.step != "" {
+			step = file.step + " " + file.status
+		}
+		lines = append(lines, fmt.Sprintf("  %-20s %s", step, name))
+	}
+
+	fmt.Fprintln(p.out, strings.Join(lines, "\n"))
+	p.lines = len(lines)
+}
+
+// From the average time per finished file so far
+func (p *Progress) eta(done int, elapsed time.Duration) string {
+	if done == 0 {
+		return "-"
+	}
+	if done >= p.total {
+		return "done"
+	}
+	remaining := time.Duration(float64(elapsed) / float64(done) * float64(p.total-done))
+	return remaining.Round(time.Second).String()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n)
+	suffix := ""
+	for _, s := range []string{"K", "M", "G", "T"} {
+		value /= unit
+		suffix = s
+		if value < unit {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f%s", value, suffix)
+}
+
+// Clears the view, writes the log line and draws the view again below it
+type progressLogWriter struct {
+	progress *Progress
+	out      io.Writer
+}
+
+func (w progressLogWriter) Write(line []byte) (int, error) {
+	w.progress.Lock()
+	defer w.progress.Unlock()
+
+	w.progress.clear()
+	n, err := w.out.Write(line)
+	w.progress.draw()
+	return n, err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type RequestKind string
+
+const (
+	UploadRequest RequestKind = "upload"
+	StatusRequest RequestKind = "status"
+)
+
+var (
+	requestsPerSecond float64
+	requestsBurst     int
+	uploadsPerSecond  float64
+	statusPerSecond   float64
+)
+
+// Token bucket: refills at rate tokens per second up to burst.
+// Waiters reserve tokens in advance, so they are served in the order they came
+type TokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Blocks until a token is available
+func (b *TokenBucket) Wait() {
+	b.WaitN(1)
+}
+
+// Blocks until n tokens are available, n should not be more than burst
+func (b *TokenBucket) WaitN(n int) {
+	b.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Limits for all requests to the NumerX host and per request kind, nil for unlimited
+var (
+	globalLimiter *TokenBucket
+	kindLimiters  = make(map[RequestKind]*TokenBucket)
+)
+
+func initRateLimits() {
+	if requestsPerSecond > 0 {
+		globalLimiter = NewTokenBucket(requestsPerSecond, requestsBurst)
+	}
+	if uploadsPerSecond > 0 {
+		kindLimiters[UploadRequest] = NewTokenBucket(uploadsPerSecond, requestsBurst)
+	}
+	if statusPerSecond > 0 {
+		kindLimiters[StatusRequest] = NewTokenBucket(statusPerSecond, requestsBurst)
+	}
+}
+
+// Waits for both the request kind and the overall limits
+func waitForRequestSlot(kind RequestKind) {
+	if limiter := kindLimiters[kind]; limiter != nil {
+		limiter.Wait()
+	}
+	if globalLimiter != nil {
+		globalLimiter.Wait()
+	}
+}
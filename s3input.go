@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Scheme          = "s3://"
+	s3DefaultEndpoint = "https://s3.amazonaws.com"
+	s3DefaultRegion   = "us-east-1"
+	emptyPayloadHash  = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat     = "20060102T150405Z"
+)
+
+var (
+	s3Location     string
+	s3Endpoint     string
+	s3Region       string
+	s3AccessKey    string
+	s3SecretKey    string
+	s3VirtualHost  bool
+	s3SuccessTag   string
+	s3DonePrefix   string
+	s3FailedPrefix string
+)
+
+// Client for any S3-compatible endpoint (AWS, MinIO...), requests are signed with AWS Signature V4
+type S3Client struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	virtualHost  bool
+	bucket       string
+	client       *http.Client
+}
+
+var s3Client *S3Client
+
+type S3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type listBucketResult struct {
+	Contents              []S3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3://bucket/prefix -> bucket, prefix
+func parseS3Location(location string) (string, string, error) {
+	if !strings.HasPrefix(location, s3Scheme) {
+		return "", "", fmt.Errorf("S3 location must look like %sbucket/prefix: %s", s3Scheme, location)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(location, s3Scheme), "/", 2)
+	if parts[0] == "" {
+		return "", "", fmt.Errorf("no bucket in S3 location: %s", location)
+	}
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// Credentials come from the flags, or from the standard AWS environment variables
+func newS3Client(bucket string) (*S3Client, error) {
+	endpoint, err := url.Parse(s3Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint must be an absolute URL: %s", s3Endpoint)
+	}
+
+	// The NumerX TLS settings do not apply to the storage endpoint
+	httpClient, err := newHTTPClient(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &S3Client{
+		endpoint:     endpoint,
+		region:       s3Region,
+		accessKey:    s3AccessKey,
+		secretKey:    s3SecretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		virtualHost:  s3VirtualHost,
+		bucket:       bucket,
+		client:       httpClient,
+	}
+	if client.accessKey == "" {
+		client.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if client.secretKey == "" {
+		client.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if client.accessKey == "" || client.secretKey == "" {
+		return nil, errors.New("S3 credentials are not provided: use -s3-access-key/-s3-secret-key or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY")
+	}
+	return client, nil
+}
+
+// Lists the objects to process under the prefix, filtered the same way as files in the working directory
+func s3InputFiles(location string) ([]InputFile, error) {
+	bucket, prefix, err := parseS3Location(location)
+	if err != nil {
+		return nil, err
+	}
+	if s3Client, err = newS3Client(bucket); err != nil {
+		return nil, err
+	}
+
+	objects, err := s3Client.ListObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := []InputFile{}
+	for _, object := range objects {
+		if !s3Filter(prefix, object.Key) {
+			continue
+		}
+		input := InputFile{
+			Name:        s3Scheme + bucket + "/" + object.Key,
+			Compression: compressionOf(object.Key),
+			S3Key:       object.Key,
+			Size:        object.Size,
+		}
+		if input.Compression == Zip {
+			fileLog(input.Name).Warnf("Skipping: zip archives are not supported in S3")
+			continue
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+// Downloads the object once to a spool file, if the body has to be read more than once:
+// for the content hash or for the HMAC signature; the upload and its retries read the spool file then
+func spoolS3Input(input InputFile) (InputFile, error) {
+	if input.S3Key == "" || input.Path != "" || (hashIndex == nil && !authReadsBody()) {
+		return input, nil
+	}
+	object, err := s3Client.GetObject(input.S3Key)
+	if err != nil {
+		return input, err
+	}
+	defer object.Close()
+
+	spool, err := newSpoolFile()
+	if err != nil {
+		return input, err
+	}
+	written, err := io.Copy(spool, object)
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(spool.Name())
+		return input, err
+	}
+
+	fileLog(input.Name).Debugf("Spooled %d bytes to %s", written, spool.Name())
+	input.Path = spool.Name()
+	return input, nil
+}
+
+// Applies the -include/-exclude patterns, -depth, hidden and temp files rules to the key relative to the prefix
+func s3Filter(prefix string, key string) bool {
+	if strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, p := range []string{s3DonePrefix, s3FailedPrefix} {
+		if p != "" && strings.HasPrefix(key, p) {
+			return false
+		}
+	}
+
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	if maxDepth >= 0 && strings.Count(rel, "/") > maxDepth {
+		return false
+	}
+	for _, segment := range strings.Split(rel, "/") {
+		if isHiddenFile(segment) {
+			return false
+		}
+	}
+
+	base := path.Base(key)
+	if !(isCsvFile(key) || isCompressedCsvFile(key)) || isTempFile(base) {
+		return false
+	}
+	if len(includePatterns) > 0 && !matchesAny(includePatterns, rel) {
+		return false
+	}
+	return !matchesAny(excludePatterns, rel)
+}
+
+// Tags the object or moves it under the done/failed prefix, once its final status is known
+func disposeS3Object(key string, succeeded bool) {
+	if succeeded && s3SuccessTag != "" {
+		if err := s3Client.TagObject(key, s3SuccessTag); err != nil {
+			logger.Errorf("Could not tag s3 object %s: %v", key, err)
+		}
+	}
+
+	targetPrefix := s3FailedPrefix
+	if succeeded {
+		targetPrefix = s3DonePrefix
+	}
+	if targetPrefix == "" {
+		return
+	}
+
+	target := strings.TrimSuffix(targetPrefix, "/") + "/" + key
+	if err := s3Client.MoveObject(key, target); err != nil {
+		logger.Errorf("Could not move s3 object %s to %s: %v", key, target, err)
+		return
+	}
+	logger.Debugf("S3 object %s -> %s", key, target)
+}
+
+func (c *S3Client) ListObjects(prefix string) ([]S3Object, error) {
+	objects := []S3Object{}
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		resp, err := c.do("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// Streams the object content, the caller closes the body
+func (c *S3Client) GetObject(key string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Sets the object tag from key=value, replacing the existing tags
+func (c *S3Client) TagObject(key string, tag string) error {
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("tag must look like key=value: %s", tag)
+	}
+
+	var body bytes.Buffer
+	body.WriteString("<Tagging><TagSet><Tag><Key>")
+	xml.EscapeText(&body, []byte(parts[0]))
+	body.WriteString("</Key><Value>")
+	xml.EscapeText(&body, []byte(parts[1]))
+	body.WriteString("</Value></Tag></TagSet></Tagging>")
+
+	sum := md5.Sum(body.Bytes())
+	headers := http.Header{}
+	headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+
+	resp, err := c.do("PUT", key, url.Values{"tagging": {""}}, headers, body.Bytes())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// S3 has no rename: copy to the new key, then delete the original
+func (c *S3Client) MoveObject(key string, target string) error {
+	headers := http.Header{}
+	headers.Set("X-Amz-Copy-Source", "/"+c.bucket+"/"+s3EscapePath(key))
+
+	resp, err := c.do("PUT", target, nil, headers, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	resp, err = c.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// URL of the key in the bucket, the bucket itself for an empty key. The key is taken as is:
+// in//a.csv or in/../a.csv are valid keys, not paths to clean
+func (c *S3Client) objectURL(key string, query url.Values) url.URL {
+	target := *c.endpoint
+	objectPath := "/" + key
+	if c.virtualHost {
+		target.Host = c.bucket + "." + target.Host
+	} else {
+		objectPath = "/" + c.bucket + objectPath
+	}
+	target.Path = strings.TrimSuffix(c.endpoint.Path, "/") + objectPath
+	target.RawPath = s3EscapePath(target.Path)
+	target.RawQuery = s3CanonicalQuery(query)
+	return target
+}
+
+// Sends a signed request for the key in the bucket, non-2xx responses are returned as errors
+func (c *S3Client) do(method string, key string, query url.Values, headers http.Header, body []byte) (*http.Response, error) {
+	target := c.objectURL(key, query)
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, target.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range headers {
+		request.Header[name] = values
+	}
+	c.sign(request, body, time.Now().UTC())
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		content, _ := ioutil.ReadAll(resp.Body)
+		var s3Err s3Error
+		if xml.Unmarshal(content, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("s3 %s %s: http %d %s: %s", method, key, resp.StatusCode, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("s3 %s %s: http %d", method, key, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// AWS Signature Version 4, see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (c *S3Client) sign(request *http.Request, body []byte, now time.Time) {
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	amzDate := now.Format(amzDateFormat)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-md5" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// URI-encodes each path segment as SigV4 expects, keeping the slashes
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// RFC 3986 encoding: only unreserved characters are left as is, spaces are %20
+func s3Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// Query sorted by key with RFC 3986 encoding, valueless parameters like ?tagging get "="
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
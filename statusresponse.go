@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type NumerXStatusResponse struct {
+	ID        string    `json:"ID"`
+	Step      string    `json:"Step"`
+	Status    string    `json:"Status"`
+	Timestamp time.Time `json:"Timestamp"` // sent as milliseconds since the epoch
+	Notes     string    `json:"Notes"`
+}
+
+// Field names accepted for each field, compared lowercase without _ and -
+var statusFieldNames = map[string][]string{
+	"id":        {"id", "jobid"},
+	"step":      {"step", "stepname"},
+	"status":    {"status", "state", "stepstatus"},
+	"timestamp": {"timestamp", "time", "ts"},
+	"notes":     {"notes", "note", "message"},
+}
+
+func normalizeFieldName(name string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// Decodes the fields whatever their case or spelling, and the timestamp from milliseconds,
+// a numeric string, or an RFC 3339 string
+func (r *NumerXStatusResponse) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	fields := map[string]json.RawMessage{}
+	for name, value := range raw {
+		fields[normalizeFieldName(name)] = value
+	}
+	field := func(name string) json.RawMessage {
+		for _, alias := range statusFieldNames[name] {
+			if value, ok := fields[alias]; ok {
+				return value
+			}
+		}
+		return nil
+	}
+
+	for name, target := range map[string]*string{"id": &r.ID, "step": &r.Step, "status": &r.Status, "notes": &r.Notes} {
+		value := field(name)
+		if value == nil || string(value) == "null" {
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			// A number or other non-string value as text
+			*target = strings.Trim(string(value), `"`)
+		}
+	}
+
+	timestamp, err := parseStatusTimestamp(field("timestamp"))
+	if err != nil {
+		return err
+	}
+	r.Timestamp = timestamp
+	return nil
+}
+
+func parseStatusTimestamp(value json.RawMessage) (time.Time, error) {
+	text := strings.Trim(string(value), `"`)
+	if text == "" || text == "null" {
+		return time.Time{}, nil
+	}
+	if millis, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	if millis, err := strconv.ParseFloat(text, 64); err == nil {
+		return time.UnixMilli(int64(millis)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("bad status timestamp %s", text)
+}
+
+func (r NumerXStatusResponse) String() string {
+	s := fmt.Sprintf("%s %s", r.Step, r.Status)
+	if !r.Timestamp.IsZero() {
+		s += " at " + r.Timestamp.Format(time.RFC3339)
+	}
+	if r.Notes != "" {
+		s += " (" + r.Notes + ")"
+	}
+	return s
+}
+
+// Unmarshal Status response to []NumerXStatusResponse, from an array or a single object
+func getStatusResponse(bodyContent []byte) ([]NumerXStatusResponse, error) {
+	trimmed := bytes.TrimSpace(bodyContent)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var single NumerXStatusResponse
+		if err := json.Unmarshal(trimmed, &single); err != nil {
+			return nil, err
+		}
+		return []NumerXStatusResponse{single}, nil
+	}
+
+	var response []NumerXStatusResponse
+	err := json.Unmarshal(trimmed, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
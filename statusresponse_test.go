@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestGetStatusResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []NumerXStatusResponse
+	}{
+		{"array",
+			`[{"ID":"0.0.a","Step":"metaindexstatus","Status":"success","Timestamp":1465589455508,"Notes":""},
+			  {"ID":"0.0.a","Step":"rawmeta","Status":"success","Timestamp":1465588543502,"Notes":""}]`,
+			[]NumerXStatusResponse{
+				{ID: "0.0.a", Step: "metaindexstatus", Status: "success", Timestamp: time.UnixMilli(1465589455508)},
+				{ID: "0.0.a", Step: "rawmeta", Status: "success", Timestamp: time.UnixMilli(1465588543502)},
+			}},
+		{"single object",
+			` {"ID":"0.0.b","Step":"parsedmeta","Status":"failed","Timestamp":1465588843502,"Notes":"bad row 3"}`,
+			[]NumerXStatusResponse{
+				{ID: "0.0.b", Step: "parsedmeta", Status: "failed", Timestamp: time.UnixMilli(1465588843502), Notes: "bad row 3"},
+			}},
+		{"lowercase fields",
+			`[{"id":"0.0.c","step":"rawevent","status":"success","timestamp":1465588543502,"notes":"ok"}]`,
+			[]NumerXStatusResponse{
+				{ID: "0.0.c", Step: "rawevent", Status: "success", Timestamp: time.UnixMilli(1465588543502), Notes: "ok"},
+			}},
+		{"field aliases",
+			`[{"job_id":"0.0.d","step_name":"rawevent","state":"success","ts":"2016-06-10T20:05:43Z","message":"m"}]`,
+			[]NumerXStatusResponse{
+				{ID: "0.0.d", Step: "rawevent", Status: "success", Timestamp: time.Date(2016, 6, 10, 20, 5, 43, 0, time.UTC), Notes: "m"},
+			}},
+		{"numeric id, null notes, no timestamp",
+			`[{"ID":42,"Step":"rawevent","Status":"success","Notes":null}]`,
+			[]NumerXStatusResponse{
+				{ID: "42", Step: "rawevent", Status: "success"},
+			}},
+		{"empty array", `[]`, []NumerXStatusResponse{}},
+	}
+
+	for _, test := range tests {
+		got, err := getStatusResponse([]byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d entries, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i := range got {
+			if got[i].ID != test.want[i].ID || got[i].Step != test.want[i].Step || got[i].Status != test.want[i].Status ||
+				got[i].Notes != test.want[i].Notes || !got[i].Timestamp.Equal(test.want[i].Timestamp) {
+				t.Errorf("%s: entry %d is %+v, want %+v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestGetStatusResponseErrors(t *testing.T) {
+	for _, body := range []string{``, `not json`, `[{"Step":"rawmeta","Timestamp":"yesterday"}]`, `{"Step":`} {
+		if _, err := getStatusResponse([]byte(body)); err == nil {
+			t.Errorf("%q: no error", body)
+		}
+	}
+}
+
+func TestParseStatusTimestamp(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{`1465589455508`, time.UnixMilli(1465589455508)},
+		{`"1465589455508"`, time.UnixMilli(1465589455508)},
+		{`1465589455508.0`, time.UnixMilli(1465589455508)},
+		{`"2016-06-10T20:10:55.508Z"`, time.Date(2016, 6, 10, 20, 10, 55, 508000000, time.UTC)},
+		{`"2016-06-10T22:10:55+02:00"`, time.Date(2016, 6, 10, 20, 10, 55, 0, time.UTC)},
+		{`null`, time.Time{}},
+		{`""`, time.Time{}},
+		{``, time.Time{}},
+	}
+
+	for _, test := range tests {
+		got, err := parseStatusTimestamp(json.RawMessage(test.value))
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%s: got %v, want %v", test.value, got, test.want)
+		}
+	}
+
+	if _, err := parseStatusTimestamp(json.RawMessage(`"June 10"`)); err == nil {
+		t.Errorf("no error for a bad timestamp")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const stdinFileName = "-"
+
+var errStreamConsumed = errors.New("input stream has already been read and was not spooled, cannot retry")
+
+// Stdin or a named pipe, which can only be read once
+type oneShotReader struct {
+	sync.Mutex
+	reader io.ReadCloser
+	used   bool
+}
+
+func (s *oneShotReader) take() (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.used {
+		return nil, errStreamConsumed
+	}
+	s.used = true
+	return s.reader, nil
+}
+
+// Temp files holding spooled streams and S3 objects, removed at the end of the run
+var spoolFiles struct {
+	sync.Mutex
+	names []string
+}
+
+// Creates a temp file to spool an input to, it is removed with removeSpoolFiles
+func newSpoolFile() (*os.File, error) {
+	spool, err := ioutil.TempFile("", "numerxdatapusher-spool-")
+	if err != nil {
+		return nil, err
+	}
+	spoolFiles.Lock()
+	spoolFiles.names = append(spoolFiles.names, spool.Name())
+	spoolFiles.Unlock()
+	return spool, nil
+}
+
+// True for stdin (-f -) and named pipes
+func isStreamInput(fileName string) bool {
+	if fileName == stdinFileName {
+		return true
+	}
+	info, err := os.Stat(fileName)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// Input for stdin or a named pipe, named by the -label in reports.
+// The stream is spooled to a temp file if the body has to be read more than once:
+// for retries, for the content hash or for the HMAC signature
+func streamInput(fileName string, label string) (InputFile, error) {
+	input := InputFile{
+		Name:        label,
+		Compression: compressionOf(label),
+		Stream:      true,
+	}
+	if input.Compression == Zip {
+		return input, errors.New("zip archives cannot be read from a stream")
+	}
+
+	var reader io.ReadCloser = os.Stdin
+	if fileName != stdinFileName {
+		pipe, err := os.Open(fileName)
+		if err != nil {
+			return input, err
+		}
+		reader = pipe
+	}
+
+	if retryNumber <= 1 && hashIndex == nil && !authReadsBody() {
+		input.stream = &oneShotReader{reader: reader}
+		return input, nil
+	}
+	defer reader.Close()
+
+	spool, err := newSpoolFile()
+	if err != nil {
+		return input, err
+	}
+
+	written, err := io.Copy(spool, reader)
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return input, err
+	}
+
+	fileLog(label).Debugf("Spooled %d bytes to %s", written, spool.Name())
+	input.Path = spool.Name()
+	return input, nil
+}
+
+func removeSpoolFiles() {
+	spoolFiles.Lock()
+	defer spoolFiles.Unlock()
+	for _, spool := range spoolFiles.names {
+		os.Remove(spool)
+	}
+}
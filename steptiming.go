@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phases of the NumerX pipeline, timed from the status timestamps
+const (
+	TimingRawToParsed   = "raw->parsed"
+	TimingParsedToIndex = "parsed->index"
+	TimingPostToIndex   = "post->index"
+)
+
+var timingPhases = []string{TimingRawToParsed, TimingParsedToIndex, TimingPostToIndex}
+
+// Durations of the pipeline phases of a job, only the ones with both ends known
+type StepTimings map[string]time.Duration
+
+func (t StepTimings) String() string {
+	parts := []string{}
+	for _, phase := range timingPhases {
+		if d, ok := t[phase]; ok {
+			parts = append(parts, fmt.Sprintf("%s %v", phase, d))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// The raw, parsed and index steps of the data type
+func pipelineSteps(rqType RQType) (raw, parsed, index string) {
+	if rqType == RQ_Viewership {
+		return string(RawEventData), string(ParsedEventData), string(IndexEventData)
+	}
+	return string(RawMetaData), string(ParsedMetaData), string(IndexMetaData)
+}
+
+// Times the phases between the steps done so far, the latest run of a step repeated by the server;
+// post->index is from the local POST time to the server index time, so it includes the clock difference between the two
+func stepTimings(job JobType, status []NumerXStatusResponse) StepTimings {
+	at := map[string]time.Time{}
+	for _, entry := range orderedByTime(status) {
+		if entry.Status == string(Success) && !entry.Timestamp.IsZero() {
+			at[entry.Step] = entry.Timestamp
+		}
+	}
+
+	raw, parsed, index := pipelineSteps(requestType)
+	timings := StepTimings{}
+	between := func(phase string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() && !to.Before(from) {
+			timings[phase] = to.Sub(from)
+		}
+	}
+	between(TimingRawToParsed, at[raw], at[parsed])
+	between(TimingParsedToIndex, at[parsed], at[index])
+	between(TimingPostToIndex, job.Posted, at[index])
+	return timings
+}
+
+// Phase durations of all jobs of the run by data type, for the summary
+var timingSamples = struct {
+	sync.Mutex
+	samples map[string]map[string][]time.Duration
+}{samples: make(map[string]map[string][]time.Duration)}
+
+func recordTimings(timings StepTimings) {
+	if len(timings) == 0 {
+		return
+	}
+	timingSamples.Lock()
+	defer timingSamples.Unlock()
+
+	byPhase := timingSamples.samples[param_RQ_T]
+	if byPhase == nil {
+		byPhase = make(map[string][]time.Duration)
+		timingSamples.samples[param_RQ_T] = byPhase
+	}
+	for phase, d := range timings {
+		byPhase[phase] = append(byPhase[phase], d)
+	}
+}
+
+// Nearest-rank percentile of sorted durations: the ceil(n*p)-th smallest
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(float64(len(sorted))*p)) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// Prints min/median/p95 of each phase per data type
+func PrintTimingSummary() {
+	timingSamples.Lock()
+	defer timingSamples.Unlock()
+
+	dataTypes := []string{}
+	for dataType := range timingSamples.samples {
+		dataTypes = append(dataTypes, dataType)
+	}
+	sort.Strings(dataTypes)
+
+	for _, dataType := range dataTypes {
+		for _, phase := range timingPhases {
+			samples := timingSamples.samples[dataType][phase]
+			if len(samples) == 0 {
+				continue
+			}
+			sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+			fmt.Printf("Step timing %s %-14s min %v, median %v, p95 %v, jobs %d\n", dataType, phase+":",
+				samples[0], percentile(samples, 0.5), percentile(samples, 0.95), len(samples))
+		}
+	}
+}
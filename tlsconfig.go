@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var (
+	caBundleFile   string
+	clientCertFile string
+	clientKeyFile  string
+	tlsMinVersion  string
+	tlsServerName  string
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLS settings for the NumerX service: internal CA bundle, client certificate for mutual TLS,
+// minimum version and server name override
+func newTLSConfig() (*tls.Config, error) {
+	minVersion, ok := tlsVersions[tlsMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q, valid values are 1.0, 1.1, 1.2, 1.3", tlsMinVersion)
+	}
+
+	config := &tls.Config{
+		MinVersion: minVersion,
+		ServerName: tlsServerName,
+	}
+
+	if caBundleFile != "" {
+		bundle, err := ioutil.ReadFile(caBundleFile)
+		if err != nil {
+			return nil, err
+		}
+		// The bundle is added to the system roots, so that public certificates keep working
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caBundleFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if clientCertFile != "" || clientKeyFile != "" {
+		if clientCertFile == "" || clientKeyFile == "" {
+			return nil, errors.New("both client certificate and key must be provided")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
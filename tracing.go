@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	traceFileName string
+	otlpEndpoint  string
+)
+
+const (
+	serviceName = "numerxdatapusher"
+
+	// OTLP span kinds and status codes
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusOk         = 1
+	statusError      = 2
+
+	otlpBatchSize     = 100
+	otlpFlushInterval = 5 * time.Second
+	otlpTimeout       = 10 * time.Second
+	spanBufferSize    = 1000 // ended spans waiting for the exporter, more are dropped
+)
+
+// Span of the upload lifecycle of a file. nil when tracing is off, all methods accept that
+type Span struct {
+	sync.Mutex
+	traceId    [16]byte
+	spanId     [8]byte
+	parentId   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	events     []spanEvent
+	seen       map[string]bool
+	failed     bool
+	message    string
+}
+
+type spanEvent struct {
+	name       string
+	time       time.Time
+	attributes map[string]string
+}
+
+func tracingEnabled() bool {
+	return traceExporter != nil
+}
+
+// Root span for a new trace, or a child of parent
+func startSpan(name string, parent *Span) *Span {
+	if !tracingEnabled() {
+		return nil
+	}
+	span := &Span{
+		name:       name,
+		kind:       spanKindInternal,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+		seen:       make(map[string]bool),
+	}
+	if parent != nil {
+		span.traceId = parent.traceId
+		span.parentId = parent.spanId
+	} else {
+		rand.Read(span.traceId[:])
+	}
+	rand.Read(span.spanId[:])
+	return span
+}
+
+// Child span for an outgoing request, its context is sent in the traceparent header
+func startRequestSpan(name string, parent *Span, request *http.Request) *Span {
+	span := startSpan(name, parent)
+	if span == nil {
+		return nil
+	}
+	span.kind = spanKindClient
+	span.SetAttribute("http.method", request.Method)
+	span.SetAttribute("http.url", request.URL.String())
+	request.Header.Set("traceparent", span.TraceParent())
+	return span
+}
+
+// W3C trace context: version-traceid-spanid-flags, sampled
+func (s *Span) TraceParent() string {
+	return fmt.Sprintf("00-%x-%x-01", s.traceId, s.spanId)
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.attributes[key] = fmt.Sprint(value)
+	s.Unlock()
+}
+
+// The event happened at the time, now if it is not known
+func (s *Span) AddEvent(name string, at time.Time, attributes map[string]string) {
+	if s == nil {
+		return
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+	s.Lock()
+	s.events = append(s.events, spanEvent{name: name, time: at, attributes: attributes})
+	s.Unlock()
+}
+
+// Adds the event only the first time for the key, as for repeated status checks
+func (s *Span) AddEventOnce(key string, name string, at time.Time, attributes map[string]string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	seen := s.seen[key]
+	s.seen[key] = true
+	s.Unlock()
+	if !seen {
+		s.AddEvent(name, at, attributes)
+	}
+}
+
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.failed = true
+	s.message = message
+	s.Unlock()
+}
+
+// Ends and exports the span
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.end = time.Now()
+	s.Unlock()
+	traceExporter.export(s)
+}
+
+// Adds a span event per step and status not seen before for the job, at the step timestamp
+func traceSteps(span *Span, status []NumerXStatusResponse) {
+	for _, entry := range status {
+		span.AddEventOnce(entry.Step+"="+entry.Status, "step "+entry.Step, entry.Timestamp, map[string]string{
+			"step":      entry.Step,
+			"status":    entry.Status,
+			"timestamp": entry.Timestamp.Format(time.RFC3339Nano),
+			"notes":     entry.Notes,
+		})
+	}
+}
+
+// OTLP/JSON encoding of spans
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// Body of the OTLP/HTTP JSON traces export request
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(attributes map[string]string) []otlpKeyValue {
+	keyValues := []otlpKeyValue{}
+	for key, value := range attributes {
+		keyValue := otlpKeyValue{Key: key}
+		keyValue.Value.StringValue = value
+		keyValues = append(keyValues, keyValue)
+	}
+	return keyValues
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.Lock()
+	defer s.Unlock()
+
+	span := otlpSpan{
+		TraceId:           hex.EncodeToString(s.traceId[:]),
+		SpanId:            hex.EncodeToString(s.spanId[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(s.end),
+		Attributes:        otlpAttributes(s.attributes),
+	}
+	if s.parentId != [8]byte{} {
+		span.ParentSpanId = hex.EncodeToString(s.parentId[:])
+	}
+	for _, event := range s.events {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: unixNano(event.time),
+			Name:         event.name,
+			Attributes:   otlpAttributes(event.attributes),
+		})
+	}
+	span.Status.Code = statusOk
+	if s.failed {
+		span.Status.Code = statusError
+		span.Status.Message = s.message
+	}
+	return span
+}
+
+// Writes each ended span as a JSON line to -trace-file, and sends them in batches to -otlp-endpoint.
+// Ending a span never waits for the exporter: spans above the buffer are dropped and counted
+type spanExporter struct {
+	sync.Mutex
+	closed  bool  // spans ended after the shutdown are dropped
+	dropped int64 // spans dropped with the buffer full
+	out     io.WriteCloser
+	client  *http.Client
+	batch   []otlpSpan
+	spans   chan otlpSpan
+	done    chan bool
+}
+
+var traceExporter *spanExporter
+
+func initTracing() error {
+	if traceFileName == "" && otlpEndpoint == "" {
+		return nil
+	}
+	exporter := &spanExporter{
+		spans: make(chan otlpSpan, spanBufferSize),
+		done:  make(chan bool),
+	}
+	switch traceFileName {
+	case "":
+	case "-":
+		exporter.out = os.Stdout
+	default:
+		file, err := os.OpenFile(traceFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		exporter.out = file
+	}
+	if otlpEndpoint != "" {
+		// Not the NumerX client: a slow collector gives up soon, and the -proxy is for the NumerX service only
+		exporter.client = &http.Client{
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
+			Timeout:   otlpTimeout,
+		}
+	}
+	traceExporter = exporter
+	go exporter.run()
+	return nil
+}
+
+func (e *spanExporter) export(span *Span) {
+	e.Lock()
+	defer e.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- span.otlp():
+	default:
+		spansDropped.Inc()
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+func (e *spanExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case span, more := <-e.spans:
+			if !more {
+				e.flush()
+				return
+			}
+			if e.out != nil {
+				line, _ := json.Marshal(span)
+				e.out.Write(append(line, '\n'))
+			}
+			if e.client != nil {
+				e.batch = append(e.batch, span)
+				if len(e.batch) >= otlpBatchSize {
+					e.flush()
+				}
+			}
+		case <-ticker.C:
+			e.flush()
+		}
+	}
+}
+
+// Sends the batch to the OTLP/HTTP collector; the spans are dropped if that fails
+func (e *spanExporter) flush() {
+	if len(e.batch) == 0 {
+		return
+	}
+	scopeSpans := otlpScopeSpans{Spans: e.batch}
+	scopeSpans.Scope.Name = serviceName
+	scopeSpans.Scope.Version = version
+	resourceSpans := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scopeSpans}}
+	resourceSpans.Resource.Attributes = otlpAttributes(map[string]string{"service.name": serviceName, "service.version": version})
+	request := otlpExportRequest{ResourceSpans: []otlpResourceSpans{resourceSpans}}
+	e.batch = nil
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		logger.Warnf("Could not encode spans: %v", err)
+		return
+	}
+	resp, err := e.client.Post(otlpEndpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Warnf("Could not export spans: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		logger.Warnf("Could not export spans: http %d %s", resp.StatusCode, truncateBody(respBody))
+	}
+}
+
+// Exports the remaining spans
+func shutdownTracing() {
+	if !tracingEnabled() {
+		return
+	}
+	traceExporter.Lock()
+	if traceExporter.closed {
+		traceExporter.Unlock()
+		return
+	}
+	traceExporter.closed = true
+	close(traceExporter.spans)
+	traceExporter.Unlock()
+	<-traceExporter.done
+	if dropped := atomic.LoadInt64(&traceExporter.dropped); dropped > 0 {
+		logger.Warnf("Dropped %d spans, the exporter could not keep up", dropped)
+	}
+	if traceExporter.out != nil && traceExporter.out != os.Stdout {
+		traceExporter.out.Close()
+	}
+}
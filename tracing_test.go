@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A stalled exporter must not block the uploads ending their spans
+func TestSpanExporterDropsWhenFull(t *testing.T) {
+	exporter := &spanExporter{spans: make(chan otlpSpan, 2), done: make(chan bool)}
+	traceExporter = exporter
+	defer func() { traceExporter = nil }()
+
+	ended := make(chan bool)
+	go func() {
+		for i := 0; i < 5; i++ {
+			startSpan("file", nil).End()
+		}
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Span.End blocked on a full exporter")
+	}
+	if len(exporter.spans) != 2 || exporter.dropped != 3 {
+		t.Errorf("got %d spans buffered, %d dropped; want 2, 3", len(exporter.spans), exporter.dropped)
+	}
+}
+
+func TestTraceStepsAtStepTime(t *testing.T) {
+	span := &Span{attributes: map[string]string{}, seen: map[string]bool{}}
+	at := time.UnixMilli(1465588543502)
+	status := []NumerXStatusResponse{{Step: "rawmeta", Status: "success", Timestamp: at}}
+	traceSteps(span, status)
+	traceSteps(span, status)
+	if len(span.events) != 1 || !span.events[0].time.Equal(at) {
+		t.Errorf("got events %+v, want one at %v", span.events, at)
+	}
+}